@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+// Cancelled subscriptions and trials ending sooner than this get a warning.
+const expiryWarning = 7 * 24 * time.Hour
+
+// The API's dates have come both with and without a time.
+func parseSubDate(date string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		parsed, err := time.Parse(layout, date)
+		if err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func formatSubDate(date string) string {
+	parsed, ok := parseSubDate(date)
+	if !ok {
+		return date
+	}
+	return parsed.Format("2006-01-02")
+}
+
+func daysLeft(date string, now time.Time) (int, bool) {
+	parsed, ok := parseSubDate(date)
+	if !ok || parsed.Before(now) || parsed.Sub(now) > expiryWarning {
+		return 0, false
+	}
+	return int(parsed.Sub(now).Hours() / 24), true
+}
+
+// Subscriptions that renew themselves don't expire, so only cancelled ones and trials are warned about.
+func getExpiryWarning(sub *beatport.UserSub, now time.Time) string {
+	s := sub.Subscription
+	days, ok := daysLeft(s.FreeTrialEndDate, now)
+	if ok {
+		return fmt.Sprintf("Warning: free trial ends in %d day(s), on %s.", days, formatSubDate(s.FreeTrialEndDate))
+	}
+	if s.RecurlySubscriptionStatus == "active" {
+		return ""
+	}
+	days, ok = daysLeft(s.EndDate, now)
+	if ok {
+		return fmt.Sprintf("Warning: subscription ends in %d day(s), on %s.", days, formatSubDate(s.EndDate))
+	}
+	return ""
+}
+
+// Checks the plan can stream, and lowers the quality to the most it allows so 256 isn't tried for nothing.
+func checkPlan(sub *beatport.UserSub, cfg *Config) error {
+	caps, known := sub.Caps()
+	if !known {
+		fmt.Printf("Unknown plan code %q, going by the plan's name.\n", sub.Subscription.Bundle.PlanCode)
+	}
+	if !caps.Streaming {
+		return errors.New("LINK or LINK Pro subscription required.")
+	}
+	if cfg.Quality > caps.MaxBitrate {
+		fmt.Printf("%s streams up to %d kbps, downloading in %d.\n", sub.PlanName(), caps.MaxBitrate, caps.MaxBitrate)
+		cfg.Quality = caps.MaxBitrate
+	}
+	warning := getExpiryWarning(sub, time.Now())
+	if warning != "" {
+		fmt.Println(warning)
+	}
+	return nil
+}
+
+func printAccount(sub *beatport.UserSub) {
+	s := sub.Subscription
+	caps, known := sub.Caps()
+	fmt.Printf("%-12s %s\n", "Plan:", sub.PlanName())
+	fmt.Printf("%-12s %s\n", "Plan code:", s.Bundle.PlanCode)
+	status := s.RecurlySubscriptionStatus
+	if !sub.Active {
+		status += " (inactive)"
+	}
+	fmt.Printf("%-12s %s\n", "Status:", status)
+	if s.StartDate != "" {
+		fmt.Printf("%-12s %s\n", "Started:", formatSubDate(s.StartDate))
+	}
+	if s.EndDate != "" {
+		label := "Ends:"
+		if s.RecurlySubscriptionStatus == "active" {
+			label = "Renews:"
+		}
+		fmt.Printf("%-12s %s\n", label, formatSubDate(s.EndDate))
+	}
+	switch {
+	case s.FreeTrialStartDate != "" && s.FreeTrialEndDate != "":
+		fmt.Printf("%-12s %s to %s\n", "Free trial:", formatSubDate(s.FreeTrialStartDate), formatSubDate(s.FreeTrialEndDate))
+	case s.FreeTrialEndDate != "":
+		fmt.Printf("%-12s until %s\n", "Free trial:", formatSubDate(s.FreeTrialEndDate))
+	}
+	streaming := "no"
+	if caps.Streaming {
+		streaming = fmt.Sprintf("yes, up to %d kbps AAC", caps.MaxBitrate)
+	}
+	if !known {
+		streaming += " (unknown plan code, going by the plan's name)"
+	}
+	fmt.Printf("%-12s %s\n", "Streaming:", streaming)
+	warning := getExpiryWarning(sub, time.Now())
+	if warning != "" {
+		fmt.Println("\n" + warning)
+	}
+}
+
+// account signs in and shows the subscription. Exits 4 if it can't stream.
+func runAccountCmd() error {
+	var args AccountArgs
+	err := parseSubArgs("account", &args)
+	if err != nil {
+		return err
+	}
+	cfg, err := readConfig(args.Config, args.Profile)
+	if err != nil {
+		return configErr("Failed to parse config file.", err)
+	}
+	applyArgs(cfg, &args)
+	err = setCfgDefaults(cfg)
+	if err != nil {
+		return configErr("Failed to parse config file.", err)
+	}
+	if cfg.CookiesPath == "" {
+		err = getCredentials(cfg)
+		if err != nil {
+			return authErr("Failed to get credentials.", err)
+		}
+	}
+	err = setupTransport(cfg)
+	if err != nil {
+		return configErr("Failed to set up HTTP transport.", err)
+	}
+	client := newClient(cfg)
+	ctx := context.Background()
+	err = signIn(ctx, client, cfg)
+	if err != nil {
+		return authErr("Failed to auth.", err)
+	}
+	sub, err := client.Subscription(ctx)
+	if err != nil {
+		return authErr("Failed to get subscription info.", err)
+	}
+	printAccount(sub)
+	caps, _ := sub.Caps()
+	if !caps.Streaming {
+		return noSubErr("LINK or LINK Pro subscription required.")
+	}
+	return nil
+}
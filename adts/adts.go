@@ -0,0 +1,121 @@
+// Package adts parses the ADTS framed AAC that Beatport's HLS segments decrypt to.
+package adts
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTruncated = errors.New("Truncated ADTS frame.")
+	ErrCorrupt   = errors.New("Corrupt ADTS stream.")
+	ErrMismatch  = errors.New("Stream parameters changed between frames.")
+)
+
+var sampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// Info sums up a run of ADTS frames.
+type Info struct {
+	SampleRate int
+	Channels   int
+	Frames     int
+	Samples    int
+}
+
+// Duration of the audio the frames hold.
+func (i *Info) Duration() time.Duration {
+	if i.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(i.Samples) * time.Second / time.Duration(i.SampleRate)
+}
+
+// Add appends another run of frames, which must have the same sample rate and channel config.
+func (i *Info) Add(o *Info) error {
+	if i.Frames == 0 {
+		*i = *o
+		return nil
+	}
+	if o.SampleRate != i.SampleRate || o.Channels != i.Channels {
+		return fmt.Errorf(
+			"%w %d Hz, %d channel(s) then %d Hz, %d channel(s).",
+			ErrMismatch, i.SampleRate, i.Channels, o.SampleRate, o.Channels,
+		)
+	}
+	i.Frames += o.Frames
+	i.Samples += o.Samples
+	return nil
+}
+
+// HLS packed audio usually starts with an ID3 tag holding the segment's timestamp.
+func skipID3(data []byte) (int, error) {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return 0, nil
+	}
+	size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+	size += 10
+	// Footer present.
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+	if size > len(data) {
+		return 0, fmt.Errorf("%w ID3 tag runs past the end of the data.", ErrTruncated)
+	}
+	return size, nil
+}
+
+// Parse walks every frame in data. It has to start on a frame, or an ID3 tag followed by one,
+// and end exactly where the last frame does.
+func Parse(data []byte) (*Info, error) {
+	offset, err := skipID3(data)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	for offset < len(data) {
+		header := data[offset:]
+		if len(header) < 7 {
+			return nil, fmt.Errorf("%w %d byte(s) left at offset %d, need 7 for a header.", ErrTruncated, len(header), offset)
+		}
+		if header[0] != 0xff || header[1]&0xf6 != 0xf0 {
+			return nil, fmt.Errorf("%w No sync word at offset %d, frame %d.", ErrCorrupt, offset, info.Frames+1)
+		}
+		srIndex := int(header[2]>>2) & 0x0f
+		if srIndex >= len(sampleRates) {
+			return nil, fmt.Errorf("%w Invalid sample rate index %d in frame %d.", ErrCorrupt, srIndex, info.Frames+1)
+		}
+		channels := int(header[2]&0x01)<<2 | int(header[3]>>6)
+		frameLen := int(header[3]&0x03)<<11 | int(header[4])<<3 | int(header[5]>>5)
+		headerLen := 7
+		// No CRC when protection_absent is set.
+		if header[1]&0x01 == 0 {
+			headerLen = 9
+		}
+		if frameLen < headerLen {
+			return nil, fmt.Errorf("%w Frame %d claims to be %d byte(s) long.", ErrCorrupt, info.Frames+1, frameLen)
+		}
+		if frameLen > len(header) {
+			return nil, fmt.Errorf(
+				"%w Frame %d is %d byte(s) long but only %d are left.", ErrTruncated, info.Frames+1, frameLen, len(header),
+			)
+		}
+		frame := &Info{
+			SampleRate: sampleRates[srIndex],
+			Channels:   channels,
+			Frames:     1,
+			Samples:    1024 * (int(header[6]&0x03) + 1),
+		}
+		err = info.Add(frame)
+		if err != nil {
+			return nil, err
+		}
+		offset += frameLen
+	}
+	if info.Frames == 0 {
+		return nil, fmt.Errorf("%w No frames.", ErrCorrupt)
+	}
+	return info, nil
+}
@@ -0,0 +1,112 @@
+package beatport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrNoCsrfToken = errors.New("Csrf token wasn't returned by server.")
+	ErrLogin       = errors.New("Redirected to login page. Bad credentials?")
+)
+
+// Beatport's streaming bundles by plan code. LINK streams 128k AAC, LINK Pro and Pro+ 256k.
+var planCaps = map[string]*PlanCaps{
+	"link":          {Streaming: true, MaxBitrate: 128},
+	"link-pro":      {Streaming: true, MaxBitrate: 256},
+	"link-pro-plus": {Streaming: true, MaxBitrate: 256},
+}
+
+func (c *Client) getCsrfToken(ctx context.Context) (string, error) {
+	_url := c.BaseURL + "account/login"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Referer", _url)
+	do, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return "", newStatusError(do)
+	}
+	for _, cookie := range do.Cookies() {
+		if cookie.Name == "_csrf_token" {
+			return cookie.Value, nil
+		}
+	}
+	return "", ErrNoCsrfToken
+}
+
+// Login signs in with the site's login form. The session's kept in the client's cookie jar.
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	csrfToken, err := c.getCsrfToken(ctx)
+	if err != nil {
+		return errors.New("Failed to get CSRF token.\n" + err.Error())
+	}
+	_url := c.BaseURL + "account/login"
+	data := url.Values{}
+	data.Set("_csrf_token", csrfToken)
+	data.Set("next", "")
+	data.Set("username", email)
+	data.Set("password", password)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, _url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Referer", _url)
+	do, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return newStatusError(do)
+	}
+	if do.Request.URL.String() == _url {
+		return ErrLogin
+	}
+	return nil
+}
+
+// Subscription fails if the session isn't signed in, so it doubles as a session check.
+func (c *Client) Subscription(ctx context.Context) (*UserSub, error) {
+	var obj UserSub
+	err := c.getJSON(ctx, c.apiURL("my/subscriptions"), c.BaseURL+"subscriptions", nil, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// PlanName is the bundle's name, e.g. Beatport LINK Pro.
+func (s *UserSub) PlanName() string {
+	name := s.Subscription.Bundle.Name
+	if name == "" {
+		return "No subscription"
+	}
+	return name
+}
+
+// Caps says what the plan allows. Codes that aren't known yet are judged by the bundle's name instead,
+// and known is false.
+func (s *UserSub) Caps() (caps *PlanCaps, known bool) {
+	bundle := s.Subscription.Bundle
+	caps, ok := planCaps[strings.ToLower(bundle.PlanCode)]
+	if ok {
+		return caps, true
+	}
+	switch {
+	case strings.Contains(bundle.Name, "LINK Pro"):
+		return &PlanCaps{Streaming: true, MaxBitrate: 256}, false
+	case strings.Contains(bundle.Name, "LINK"):
+		return &PlanCaps{Streaming: true, MaxBitrate: 128}, false
+	}
+	return &PlanCaps{}, false
+}
@@ -0,0 +1,111 @@
+// Package beatport is a client for the parts of Beatport's v4 API the downloader uses:
+// signing in, subscriptions, releases, tracks, streams, and label and artist release lists.
+package beatport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+)
+
+const (
+	DefaultBaseURL   = "https://www.beatport.com/"
+	DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit" +
+		"/537.36 (KHTML, like Gecko) Chrome/99.0.4844.82 Safari/537.36"
+	apiPath = "api/v4/"
+)
+
+// StatusError is returned for any response other than the one a call expects.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+func newStatusError(resp *http.Response) error {
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// Client talks to one Beatport site. Its fields can be changed before the first call.
+type Client struct {
+	// Site root, ending in a slash.
+	BaseURL string
+	// API root, ending in a slash. Empty means BaseURL's api/v4/.
+	APIURL    string
+	UserAgent string
+	// Holds the session's cookies in its jar, so each account needs its own.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for beatport.com with its own cookie jar.
+func NewClient() *Client {
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		UserAgent:  DefaultUserAgent,
+		HTTPClient: &http.Client{Jar: jar},
+	}
+}
+
+func (c *Client) apiURL(path string) string {
+	if c.APIURL != "" {
+		return c.APIURL + path
+	}
+	return c.BaseURL + apiPath + path
+}
+
+// Do sends req with the client's user agent. The response is returned whatever its status.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+// Get fetches anything the API points to, e.g. covers, playlists and keys.
+func (c *Client) Get(ctx context.Context, _url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// SetCookies adds cookies for host to the session, e.g. ones exported from a signed in browser.
+func (c *Client) SetCookies(host string, cookies []*http.Cookie) {
+	c.HTTPClient.Jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: "/"}, cookies)
+}
+
+// Referer is the page the request would've come from in a browser. Empty means the site root.
+func (c *Client) getJSON(ctx context.Context, _url, referer string, query url.Values, obj interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url, nil)
+	if err != nil {
+		return err
+	}
+	if query != nil {
+		req.URL.RawQuery = query.Encode()
+	}
+	if referer == "" {
+		referer = c.BaseURL
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Referer", referer)
+	do, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return newStatusError(do)
+	}
+	return json.NewDecoder(do.Body).Decode(obj)
+}
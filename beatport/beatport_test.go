@@ -0,0 +1,351 @@
+package beatport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := NewClient()
+	c.BaseURL = srv.URL + "/"
+	c.UserAgent = "test-agent"
+	return c
+}
+
+// newLoginMux returns a mux that lets any credentials sign in, setting the csrf-value and
+// session-value cookies, for tests that check they don't leak. Its pages hold form-csrf-value
+// and the account's name, Jane Doe.
+func newLoginMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/account/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			http.SetCookie(w, &http.Cookie{Name: "_csrf_token", Value: "csrf-value", Path: "/"})
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<form><input type="hidden" name="csrfmiddlewaretoken" value="form-csrf-value"></form>`))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "session-value", Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<span class="account">Jane Doe</span>`))
+		}
+	})
+	return mux
+}
+
+func TestLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/account/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			http.SetCookie(w, &http.Cookie{Name: "_csrf_token", Value: "token", Path: "/"})
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("_csrf_token") != "token" || r.PostForm.Get("password") != "right" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	c := newTestClient(t, mux)
+	err := c.Login(context.Background(), "a@example.com", "wrong")
+	if !errors.Is(err, ErrLogin) {
+		t.Fatalf("got error %v, want %v", err, ErrLogin)
+	}
+	err = c.Login(context.Background(), "a@example.com", "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetJSON(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/catalog/releases/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-agent" || r.Header.Get("Referer") != "https://ref/" {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id": 1, "name": "Kindred", "tracks": ["b", "a"]}`))
+	})
+	mux.HandleFunc("/api/v4/catalog/tracks/2/stream", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("end") != "5000" {
+			http.Error(w, "bad end", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"stream_url": "https://example.com/file.128k.aac.m3u8"}`))
+	})
+	c := newTestClient(t, mux)
+	ctx := context.Background()
+	release, err := c.Release(ctx, "1", "https://ref/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release.Name != "Kindred" || len(release.Tracks) != 2 {
+		t.Fatalf("got %+v", release)
+	}
+	stream, err := c.Stream(ctx, "2", "", 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.StreamURL != "https://example.com/file.128k.aac.m3u8" {
+		t.Fatalf("got %q", stream.StreamURL)
+	}
+	_, err = c.Track(ctx, "3", "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("got error %v, want a 404 StatusError", err)
+	}
+}
+
+func TestReleasePages(t *testing.T) {
+	var srvURL string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/catalog/releases/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("label_id") != "7":
+			http.Error(w, "bad label", http.StatusBadRequest)
+		case r.URL.Query().Get("page") == "2":
+			w.Write([]byte(`{"results": [{"id": 2, "slug": "two"}]}`))
+		default:
+			w.Write([]byte(`{"results": [{"id": 1, "slug": "one"}], "next": "` + srvURL + `/api/v4/catalog/releases/?label_id=7&page=2"}`))
+		}
+	})
+	c := newTestClient(t, mux)
+	srvURL = c.BaseURL[:len(c.BaseURL)-1]
+	ctx := context.Background()
+	var ids []int
+	page, err := c.LabelReleases(ctx, "7")
+	for err == nil && page != nil {
+		for _, release := range page.Results {
+			ids = append(ids, release.ID)
+		}
+		page, err = c.NextPage(ctx, page)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("got %v, want [1 2]", ids)
+	}
+	if got := c.ReleaseURL(&ReleaseSummary{ID: 2, Slug: "two"}); got != c.BaseURL+"release/two/2" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestCaps(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       string
+		bundle     string
+		streaming  bool
+		maxBitrate int
+		known      bool
+	}{
+		{"link", "link", "Beatport LINK", true, 128, true},
+		{"link pro", "LINK-PRO", "Beatport LINK Pro", true, 256, true},
+		{"unknown pro", "new-code", "Beatport LINK Pro+", true, 256, false},
+		{"unknown link", "new-code", "Beatport LINK", true, 128, false},
+		{"none", "", "", false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sub UserSub
+			sub.Subscription.Bundle.PlanCode = tt.code
+			sub.Subscription.Bundle.Name = tt.bundle
+			caps, known := sub.Caps()
+			if caps.Streaming != tt.streaming || caps.MaxBitrate != tt.maxBitrate || known != tt.known {
+				t.Fatalf("got %+v, %t", caps, known)
+			}
+		})
+	}
+}
+
+func TestCassette(t *testing.T) {
+	var srvURL string
+	mux := newLoginMux()
+	mux.HandleFunc("/api/v4/catalog/releases/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1, "name": "Kindred"}`))
+	})
+	// Signed like CloudFront's, with the &s escaped as Go's JSON encoder does.
+	mux.HandleFunc("/api/v4/catalog/tracks/2/stream", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"stream_url": srvURL + "/file.m3u8?Policy=policy-value&Signature=sig-value&Key-Pair-Id=pair-value",
+		})
+	})
+	mux.HandleFunc("/file.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key/1?token=token-value\"\n#EXTINF:4,\nseg/0.aac?Signature=sig-value\n"))
+	})
+	mux.HandleFunc("/key/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0xfe, 0x00})
+	})
+	mux.HandleFunc("/api/v4/my/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"subscription": {"id": 9007199254740993, "bundle": {"name": "LINK Pro", "plan_code": "link-pro"}},
+			"billing_info": {
+				"first_name": "Jane", "last_name": "Doe", "address1": "1 Main St", "city": "Springfield",
+				"zip": "90210", "country": "US", "card_type": "Visa", "last_four": "4242"
+			},
+			"active": true
+		}`))
+	})
+	srv := httptest.NewServer(mux)
+	srvURL = srv.URL
+	ctx := context.Background()
+	get := func(c *Client, _url string) []byte {
+		t.Helper()
+		resp, err := c.Get(ctx, _url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	// Returns the playlist and key.
+	run := func(transport http.RoundTripper) (string, []byte) {
+		t.Helper()
+		c := NewClient()
+		c.BaseURL = srv.URL + "/"
+		c.HTTPClient.Transport = transport
+		err := c.Login(ctx, "a@example.com", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release, err := c.Release(ctx, "1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if release.Name != "Kindred" {
+			t.Fatalf("got %+v", release)
+		}
+		sub, err := c.Subscription(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub.PlanName() != "LINK Pro" || sub.Subscription.ID != 9007199254740993 || !sub.Active {
+			t.Fatalf("got %+v", sub)
+		}
+		stream, err := c.Stream(ctx, "2", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(get(c, stream.StreamURL)), get(c, srv.URL+"/key/1?token=token-value")
+	}
+	var cassette bytes.Buffer
+	_, recorded := run(NewRecorder(&cassette, nil))
+	srv.Close()
+	secrets := []string{
+		"a%40example.com", "secret", "csrf-value", "session-value", "policy-value", "sig-value", "pair-value", "token-value",
+		// Quoted, so they can't match the server's port.
+		"Jane", "Doe", "Main St", "Springfield", `"90210"`, "Visa", `"4242"`,
+	}
+	for _, secret := range secrets {
+		if strings.Contains(cassette.String(), secret) {
+			t.Fatalf("cassette holds %q:\n%s", secret, cassette.String())
+		}
+	}
+	replayer, err := NewReplayer(&cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlist, replayed := run(replayer)
+	if !bytes.Equal(replayed, recorded) {
+		t.Fatalf("got key %x, want %x", replayed, recorded)
+	}
+	if !strings.Contains(playlist, `URI="key/1?token=REDACTED"`) || !strings.Contains(playlist, "seg/0.aac?Signature=REDACTED\n") {
+		t.Fatalf("got playlist %q", playlist)
+	}
+	_, err = replayer.RoundTrip(httptest.NewRequest(http.MethodGet, srv.URL+"/key/2", nil))
+	if err == nil {
+		t.Fatal("got a response for a request that wasn't recorded")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	mux := newLoginMux()
+	mux.HandleFunc("/api/v4/catalog/releases/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"detail": "Region blocked."}`))
+	})
+	mux.HandleFunc("/key/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("key-bytes\xff"))
+	})
+	// Flushed before the end, so it's sent chunked with no Content-Length.
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("12345"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("678"))
+	})
+	c := newTestClient(t, mux)
+	var trace bytes.Buffer
+	c.HTTPClient.Transport = NewTracer(&trace, nil)
+	ctx := context.Background()
+	err := c.Login(ctx, "a@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Release(ctx, "1", "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("got error %v, want a 403 StatusError", err)
+	}
+	resp, err := c.Get(ctx, c.BaseURL+"key/1?Signature=sig-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = c.Get(ctx, c.BaseURL+"chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp, err = c.Get(ctx, c.BaseURL+"chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadFull(resp.Body, make([]byte, 3))
+	resp.Body.Close()
+	log := trace.String()
+	for _, want := range []string{
+		"POST " + c.BaseURL + "account/login 302 Found",
+		"< Set-Cookie: sessionid=REDACTED",
+		"> Cookie: sessionid=REDACTED",
+		"releases/1 403 Forbidden",
+		`< Body: {"detail": "Region blocked."}`,
+		"key/1?Signature=REDACTED 200 OK",
+		"10 B\n",
+		"chunked 200 OK",
+		" 8 B\n",
+		" at least 3 B\n",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("trace doesn't hold %q:\n%s", want, log)
+		}
+	}
+	for _, secret := range []string{"example.com", "secret", "csrf-value", "session-value", "sig-value", "key-bytes", "Jane Doe"} {
+		if strings.Contains(log, secret) {
+			t.Errorf("trace holds %q:\n%s", secret, log)
+		}
+	}
+}
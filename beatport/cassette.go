@@ -0,0 +1,320 @@
+package beatport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const redacted = "REDACTED"
+
+var (
+	// Login form fields that never make it into a cassette.
+	secretFormFields = []string{"_csrf_token", "username", "password"}
+	// JSON fields about the account holder, e.g. /my/subscriptions' billing_info. Everything under them's blanked.
+	personalFields = []string{"billing_info", "email", "username", "first_name", "last_name", "phone", "address1", "address2", "zip", "last_four"}
+	// URLs in JSON bodies, e.g. /stream's signed stream_url.
+	bodyUrlRegex = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	// Key and map URIs in playlists.
+	uriAttrRegex = regexp.MustCompile(`URI="[^"]*"`)
+)
+
+// Interaction is one request and the response it got. A cassette file is one per line.
+type Interaction struct {
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	RequestBody   string      `json:"request_body,omitempty"`
+	StatusCode    int         `json:"status_code"`
+	Status        string      `json:"status"`
+	Header        http.Header `json:"header,omitempty"`
+	// Text bodies are kept as is so cassettes can be read and edited. Others, e.g. segments, are base64.
+	Body       string `json:"body,omitempty"`
+	BinaryBody []byte `json:"binary_body,omitempty"`
+}
+
+// Cookies are kept by name with their values blanked, so replayed sign ins still find the ones they look for.
+func scrubHeader(header http.Header) http.Header {
+	scrubbed := header.Clone()
+	scrubbed.Del("Cookie")
+	scrubbed.Del("Authorization")
+	for i, value := range scrubbed["Set-Cookie"] {
+		name, attrs := value, ""
+		if idx := strings.Index(value, ";"); idx != -1 {
+			name, attrs = value[:idx], value[idx:]
+		}
+		if idx := strings.Index(name, "="); idx != -1 {
+			name = name[:idx]
+		}
+		scrubbed["Set-Cookie"][i] = name + "=" + redacted + attrs
+	}
+	// Referer and Location can be signed URLs.
+	for name, values := range scrubbed {
+		if name == "Set-Cookie" {
+			continue
+		}
+		for i, value := range values {
+			values[i] = redactURL(value)
+		}
+	}
+	return scrubbed
+}
+
+func isPersonal(key string) bool {
+	for _, field := range personalFields {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strings and numbers under a personal field are blanked. Numbers keep their type, so replays still decode.
+func scrubJSONValue(value interface{}, personal bool) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = scrubJSONValue(child, personal || isPersonal(key))
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = scrubJSONValue(child, personal)
+		}
+	case string:
+		if personal {
+			return redacted
+		}
+		return bodyUrlRegex.ReplaceAllStringFunc(value, redactURL)
+	case json.Number:
+		if personal {
+			return json.Number("0")
+		}
+	}
+	return value
+}
+
+func scrubJSON(body string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	// Keeps big IDs exact.
+	dec.UseNumber()
+	var value interface{}
+	err := dec.Decode(&value)
+	if err != nil || dec.More() {
+		return "", false
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(scrubJSONValue(value, false))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+// Signed URL params are blanked wherever a URL can turn up in a text body, and personal fields in
+// JSON ones. Replays request the blanked URLs, which match the blanked ones they were recorded under.
+// HTML pages aren't kept at all, as they can hold form CSRF tokens and account details, and nothing
+// reads them; sign in only looks at the status and cookies.
+func scrubBody(contentType, body string) string {
+	if strings.Contains(strings.ToLower(contentType), "html") {
+		return ""
+	}
+	trimmed := strings.TrimSpace(body)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if scrubbed, ok := scrubJSON(body); ok {
+			return scrubbed
+		}
+	}
+	if strings.HasPrefix(body, "#EXTM3U") {
+		lines := strings.Split(body, "\n")
+		for i, line := range lines {
+			if strings.HasPrefix(line, "#") {
+				lines[i] = uriAttrRegex.ReplaceAllStringFunc(line, func(attr string) string {
+					return `URI="` + redactURL(attr[len(`URI="`):len(attr)-1]) + `"`
+				})
+			} else {
+				lines[i] = redactURL(line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	// Not JSON after all, but JSON encoders tend to escape the & between params.
+	body = strings.ReplaceAll(body, `\u0026`, "&")
+	return bodyUrlRegex.ReplaceAllStringFunc(body, redactURL)
+}
+
+func scrubForm(body string) string {
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return redacted
+	}
+	for _, field := range secretFormFields {
+		if _, ok := values[field]; ok {
+			values.Set(field, redacted)
+		}
+	}
+	return values.Encode()
+}
+
+// Recorder is a transport that writes every request and response it passes on to a cassette,
+// with credentials, cookies and personal details scrubbed. It's safe for concurrent use.
+type Recorder struct {
+	// Transport that does the actual requests. Nil means http.DefaultTransport.
+	Next http.RoundTripper
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewRecorder returns a recorder that appends interactions to w as they finish,
+// so a run that dies part way through still leaves a usable cassette.
+func NewRecorder(w io.Writer, next http.RoundTripper) *Recorder {
+	return &Recorder{Next: next, w: w}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	interaction := &Interaction{
+		Method:        req.Method,
+		URL:           redactURL(req.URL.String()),
+		RequestHeader: scrubHeader(req.Header),
+	}
+	if req.Body != nil {
+		reqBody, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			interaction.RequestBody = scrubForm(string(reqBody))
+		} else if len(reqBody) > 0 {
+			interaction.RequestBody = redacted
+		}
+	}
+	next := r.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	interaction.StatusCode = resp.StatusCode
+	interaction.Status = resp.Status
+	interaction.Header = scrubHeader(resp.Header)
+	if utf8.Valid(body) {
+		interaction.Body = scrubBody(resp.Header.Get("Content-Type"), string(body))
+	} else {
+		interaction.BinaryBody = body
+	}
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(line, '\n'))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write to cassette.\n%s", err)
+	}
+	return resp, nil
+}
+
+// Replayer is a transport that answers requests from a cassette instead of the network.
+// Requests are matched by method and URL, in the order they were recorded. Once every
+// match has been used, the last one's repeated, so retries still get an answer.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewReplayer reads a cassette written by a Recorder.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	var interactions []*Interaction
+	scanner := bufio.NewScanner(r)
+	// Segments make for long lines.
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var interaction Interaction
+		err := json.Unmarshal(line, &interaction)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse interaction %d.\n%s", len(interactions)+1, err)
+		}
+		interactions = append(interactions, &interaction)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{interactions: interactions, used: make([]bool, len(interactions))}, nil
+}
+
+// LoadCassette opens a cassette file and reads it with NewReplayer.
+func LoadCassette(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplayer(f)
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	// Recorded URLs have their signed params blanked.
+	_url := redactURL(req.URL.String())
+	r.mu.Lock()
+	var found *Interaction
+	for i, interaction := range r.interactions {
+		if interaction.Method != req.Method || interaction.URL != _url {
+			continue
+		}
+		found = interaction
+		if !r.used[i] {
+			r.used[i] = true
+			break
+		}
+	}
+	r.mu.Unlock()
+	if found == nil {
+		return nil, fmt.Errorf("No recorded response for %s %s.", req.Method, _url)
+	}
+	body := found.BinaryBody
+	if body == nil {
+		body = []byte(found.Body)
+	}
+	return &http.Response{
+		Status:        found.Status,
+		StatusCode:    found.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        found.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package beatport
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Release's tracks are API URLs in the order the API returns them.
+func (c *Client) Release(ctx context.Context, id, referer string) (*Release, error) {
+	var obj Release
+	err := c.getJSON(ctx, c.apiURL("catalog/releases/"+id), referer, nil, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (c *Client) Track(ctx context.Context, id, referer string) (*Track, error) {
+	var obj Track
+	err := c.getJSON(ctx, c.apiURL("catalog/tracks/"+id), referer, nil, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// Stream gets the HLS manifest URL for the first sampleEnd ms of a track. Pass the track's
+// SampleEndMs for the whole thing.
+func (c *Client) Stream(ctx context.Context, id, referer string, sampleEnd int) (*Stream, error) {
+	query := url.Values{}
+	query.Set("start", "0")
+	query.Set("end", strconv.Itoa(sampleEnd))
+	var obj Stream
+	err := c.getJSON(ctx, c.apiURL("catalog/tracks/"+id+"/stream"), referer, query, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (c *Client) releasesBy(ctx context.Context, kind, id string) (*ReleaseList, error) {
+	query := url.Values{}
+	query.Set(kind+"_id", id)
+	query.Set("order_by", "-publish_date")
+	query.Set("per_page", "100")
+	var obj ReleaseList
+	err := c.getJSON(ctx, c.apiURL("catalog/releases/"), "", query, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// LabelReleases gets the first page of a label's releases, newest first. See NextPage.
+func (c *Client) LabelReleases(ctx context.Context, id string) (*ReleaseList, error) {
+	return c.releasesBy(ctx, "label", id)
+}
+
+// ArtistReleases gets the first page of an artist's releases, newest first. See NextPage.
+func (c *Client) ArtistReleases(ctx context.Context, id string) (*ReleaseList, error) {
+	return c.releasesBy(ctx, "artist", id)
+}
+
+// NextPage returns nil when list is the last page.
+func (c *Client) NextPage(ctx context.Context, list *ReleaseList) (*ReleaseList, error) {
+	if list.Next == "" {
+		return nil, nil
+	}
+	var obj ReleaseList
+	err := c.getJSON(ctx, list.Next, "", nil, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// ReleaseURL is the release's page on the site, as taken by the downloader.
+func (c *Client) ReleaseURL(release *ReleaseSummary) string {
+	return c.BaseURL + "release/" + release.Slug + "/" + strconv.Itoa(release.ID)
+}
@@ -0,0 +1,172 @@
+package beatport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const tracePreviewSize = 512
+
+var (
+	// Headers worth seeing when a request goes wrong. Cookies are logged by name only.
+	traceReqHeaders  = []string{"Referer", "Content-Type", "Accept"}
+	traceRespHeaders = []string{"Content-Type", "Location", "Retry-After"}
+	// Query params that are blanked in logged URLs, e.g. tokens and signed stream URL params.
+	secretParams = []string{"password", "token", "_csrf_token", "csrfmiddlewaretoken", "signature", "policy", "key-pair-id"}
+)
+
+// Tracer is a transport that logs the method, URL, status, timing, size and a few headers of
+// every request. Passwords, CSRF tokens and cookie values are never logged, and bodies are only
+// previewed for failed requests with text bodies, so key bytes don't end up in the log either.
+// A request's entry is written once its body's been read to the end or closed, so its size is
+// the bytes actually read, even for chunked responses. It's safe for concurrent use.
+type Tracer struct {
+	// Transport that does the actual requests. Nil means http.DefaultTransport.
+	Next http.RoundTripper
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewTracer returns a tracer that writes to w.
+func NewTracer(w io.Writer, next http.RoundTripper) *Tracer {
+	return &Tracer{Next: next, w: w}
+}
+
+func redactURL(_url string) string {
+	idx := strings.Index(_url, "?")
+	if idx == -1 {
+		return _url
+	}
+	params := strings.Split(_url[idx+1:], "&")
+	for i, param := range params {
+		name := param
+		if eq := strings.Index(param, "="); eq != -1 {
+			name = param[:eq]
+		}
+		for _, secret := range secretParams {
+			if strings.EqualFold(name, secret) {
+				params[i] = name + "=" + redacted
+				break
+			}
+		}
+	}
+	return _url[:idx+1] + strings.Join(params, "&")
+}
+
+// traceBody counts the bytes read from a response body and calls done once, at EOF or Close.
+type traceBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64, eof bool)
+}
+
+func (b *traceBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.n, true) })
+	}
+	return n, err
+}
+
+func (b *traceBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n, false) })
+	return err
+}
+
+func isText(contentType string, body []byte) bool {
+	contentType = strings.ToLower(contentType)
+	// Not HTML, as pages can hold CSRF tokens in their forms.
+	if !strings.HasPrefix(contentType, "text/plain") && !strings.Contains(contentType, "json") {
+		return false
+	}
+	return utf8.Valid(body)
+}
+
+func (t *Tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var buf bytes.Buffer
+	start := time.Now()
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	elapsed := time.Since(start)
+	fmt.Fprintf(&buf, "%s %s %s", start.Format("2006-01-02 15:04:05.000"), req.Method, redactURL(req.URL.String()))
+	if err != nil {
+		fmt.Fprintf(&buf, " failed after %s: %s\n", elapsed.Round(time.Millisecond), err)
+		writeReqHeaders(&buf, req)
+		t.write(buf.Bytes())
+		return nil, err
+	}
+	fmt.Fprintf(&buf, " %s %s", resp.Status, elapsed.Round(time.Millisecond))
+	// The size goes on the end of the first line, so the rest is kept until it's known.
+	var rest bytes.Buffer
+	writeReqHeaders(&rest, req)
+	for _, name := range traceRespHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			fmt.Fprintf(&rest, "    < %s: %s\n", name, redactURL(value))
+		}
+	}
+	for _, cookie := range resp.Cookies() {
+		fmt.Fprintf(&rest, "    < Set-Cookie: %s=%s\n", cookie.Name, redacted)
+	}
+	var previewed int64
+	if resp.StatusCode >= 400 {
+		preview, err := io.ReadAll(io.LimitReader(resp.Body, tracePreviewSize))
+		if err == nil {
+			previewed = int64(len(preview))
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(preview), resp.Body), resp.Body}
+			if isText(resp.Header.Get("Content-Type"), preview) {
+				fmt.Fprintf(&rest, "    < Body: %s\n", strings.Join(strings.Fields(string(preview)), " "))
+			} else if len(preview) > 0 {
+				fmt.Fprintf(&rest, "    < Body: %d byte(s) of %s, not logged\n", len(preview), resp.Header.Get("Content-Type"))
+			}
+		}
+	}
+	resp.Body = &traceBody{ReadCloser: resp.Body, done: func(n int64, eof bool) {
+		// Closed before the end, so fall back to Content-Length or what's been seen so far.
+		switch {
+		case eof:
+			fmt.Fprintf(&buf, " %d B\n", n)
+		case resp.ContentLength >= 0:
+			fmt.Fprintf(&buf, " %d B\n", resp.ContentLength)
+		default:
+			if previewed > n {
+				n = previewed
+			}
+			fmt.Fprintf(&buf, " at least %d B\n", n)
+		}
+		buf.Write(rest.Bytes())
+		t.write(buf.Bytes())
+	}}
+	return resp, nil
+}
+
+func writeReqHeaders(buf *bytes.Buffer, req *http.Request) {
+	for _, name := range traceReqHeaders {
+		if value := req.Header.Get(name); value != "" {
+			fmt.Fprintf(buf, "    > %s: %s\n", name, redactURL(value))
+		}
+	}
+	for _, cookie := range req.Cookies() {
+		fmt.Fprintf(buf, "    > Cookie: %s=%s\n", cookie.Name, redacted)
+	}
+}
+
+func (t *Tracer) write(p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(p)
+}
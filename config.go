@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	appName   = "beatport-downloader"
+	cfgFname  = "config.json"
+	envPrefix = "BP_"
+)
+
+// Where to look for a config file when one isn't given, best first.
+func getConfigPaths() []string {
+	var paths []string
+	xdgDir := os.Getenv("XDG_CONFIG_HOME")
+	if xdgDir != "" {
+		paths = append(paths, filepath.Join(xdgDir, appName, cfgFname))
+	}
+	// ~/.config on Linux, AppData on Windows and Application Support on macOS.
+	cfgDir, err := os.UserConfigDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(cfgDir, appName, cfgFname))
+	}
+	homeDir, err := os.UserHomeDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(homeDir, "."+appName, cfgFname))
+	}
+	// Where it always used to be.
+	scriptDir, err := getScriptDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(scriptDir, cfgFname))
+	}
+	return paths
+}
+
+// --config, then BP_CONFIG, then the first config file found. No config file at all is fine,
+// everything can come from env vars and args instead.
+func findConfig(cfgPath string) (string, error) {
+	if cfgPath == "" {
+		cfgPath = os.Getenv(envPrefix + "CONFIG")
+	}
+	if cfgPath != "" {
+		return cfgPath, nil
+	}
+	for _, path := range getConfigPaths() {
+		exists, err := fileExists(path)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// OutPath -> OUT_PATH.
+func getEnvName(fieldName string) string {
+	var buffer strings.Builder
+	runes := []rune(fieldName)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+			buffer.WriteRune('_')
+		}
+		buffer.WriteRune(unicode.ToUpper(r))
+	}
+	return buffer.String()
+}
+
+// Every config field can be overridden by BP_<FIELD>, and nested ones by BP_<STRUCT>_<FIELD>,
+// e.g. BP_OUT_PATH or BP_WATCH_INTERVAL. Lists are separated by semicolons as filters can hold commas.
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := prefix + getEnvName(field.Name)
+		fieldVal := v.Field(i)
+		if fieldVal.Kind() == reflect.Struct {
+			err := applyEnv(fieldVal, name+"_")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch fieldVal.Kind() {
+		case reflect.String:
+			fieldVal.SetString(value)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("Invalid %s: %s", name, err)
+			}
+			fieldVal.SetBool(parsed)
+		case reflect.Int:
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("Invalid %s: %s", name, err)
+			}
+			fieldVal.SetInt(int64(parsed))
+		case reflect.Slice:
+			var values []string
+			for _, item := range strings.Split(value, ";") {
+				item = strings.TrimSpace(item)
+				if item != "" {
+					values = append(values, item)
+				}
+			}
+			fieldVal.Set(reflect.ValueOf(values))
+		default:
+			return errors.New("Unsupported env var: " + name)
+		}
+	}
+	return nil
+}
+
+// Credentials are swapped as a set, so one account's password is never tried with another's email.
+func applyProfile(cfg *Config, name string) error {
+	profile := cfg.Profiles[name]
+	if profile == nil {
+		return errors.New("No such profile: " + name)
+	}
+	if profile.Email != "" || profile.Password != "" || profile.PasswordCommand != "" ||
+		profile.PasswordFile != "" || profile.CookiesPath != "" {
+		cfg.Email, cfg.Password, cfg.PasswordCommand, cfg.PasswordFile, cfg.CookiesPath = "", "", "", "", ""
+	}
+	cfgVal := reflect.ValueOf(cfg).Elem()
+	profileVal := reflect.ValueOf(profile).Elem()
+	for i := 0; i < profileVal.NumField(); i++ {
+		value := profileVal.Field(i)
+		if !value.IsZero() {
+			cfgVal.FieldByName(profileVal.Type().Field(i).Name).Set(value)
+		}
+	}
+	return nil
+}
+
+// Where the queue, work folder, watch state and daemon jobs go. Not next to the config file,
+// which could be in /etc or a read-only install dir. Relative output paths are left relative
+// to wherever we were run from.
+func getStateDir() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		var err error
+		stateDir, err = os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(stateDir, appName), nil
+}
+
+func resolveStatePath(stateDir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(stateDir, path)
+	}
+	return path
+}
+
+// Only made by commands that write state, so config show and validate don't touch the disk.
+func makeStateDirs(cfg *Config) error {
+	for _, path := range []string{cfg.QueuePath, cfg.WorkPath, cfg.Watch.StatePath, cfg.Daemon.JobsPath} {
+		if path != cfg.WorkPath {
+			path = filepath.Dir(path)
+		}
+		err := makeDirs(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func flattenArgs(v reflect.Value, f func(field reflect.StructField, value reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			flattenArgs(v.Field(i), f)
+			continue
+		}
+		f(field, v.Field(i))
+	}
+}
+
+// Args are the last layer, so any that were given override the config file and env vars.
+// Pointers are only nil if their flag wasn't given, the rest go unset if they're empty.
+func applyArgs(cfg *Config, args interface{}) {
+	cfgVal := reflect.ValueOf(cfg).Elem()
+	flattenArgs(reflect.ValueOf(args).Elem(), func(field reflect.StructField, value reflect.Value) {
+		cfgName := field.Tag.Get("cfg")
+		if cfgName == "-" || value.IsZero() {
+			return
+		}
+		if value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+		if cfgName == "" {
+			cfgName = field.Name
+		}
+		dest := cfgVal
+		for _, name := range strings.Split(cfgName, ".") {
+			dest = dest.FieldByName(name)
+		}
+		dest.Set(value)
+	})
+}
+
+func lowerFirst(s string) string {
+	runes := []rune(s)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+// Config as it'd be written in config.json, with secrets blanked out.
+func getCfgMap(v reflect.Value) map[string]interface{} {
+	cfgMap := map[string]interface{}{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		key := lowerFirst(field.Name)
+		fieldVal := v.Field(i)
+		switch {
+		case fieldVal.Kind() == reflect.Struct:
+			cfgMap[key] = getCfgMap(fieldVal)
+		case fieldVal.Kind() == reflect.Map:
+			profiles := map[string]interface{}{}
+			iter := fieldVal.MapRange()
+			for iter.Next() {
+				if iter.Value().IsNil() {
+					profiles[iter.Key().String()] = nil
+					continue
+				}
+				profiles[iter.Key().String()] = getCfgMap(iter.Value().Elem())
+			}
+			cfgMap[key] = profiles
+		case field.Tag.Get("secret") != "" && !fieldVal.IsZero():
+			cfgMap[key] = "[redacted]"
+		default:
+			cfgMap[key] = fieldVal.Interface()
+		}
+	}
+	return cfgMap
+}
+
+func showConfig(cfg *Config) error {
+	if cfg.Path == "" {
+		fmt.Println("No config file, settings are from env vars, args and defaults.")
+	} else {
+		fmt.Println("Config file: " + cfg.Path)
+	}
+	data, err := json.MarshalIndent(getCfgMap(reflect.ValueOf(cfg).Elem()), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// Catches typos in option names, which would otherwise be silently ignored.
+func checkUnknownKeys(cfgPath string) error {
+	data, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var obj Config
+	return dec.Decode(&obj)
+}
+
+func validateConfig(cfg *Config) []error {
+	var errs []error
+	if cfg.Path != "" {
+		err := checkUnknownKeys(cfg.Path)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.CookiesPath != "" {
+		data, err := ioutil.ReadFile(cfg.CookiesPath)
+		if err == nil {
+			_, err = parseCookies(string(data))
+		}
+		if err != nil {
+			errs = append(errs, errors.New("Bad cookies file.\n"+err.Error()))
+		}
+	} else if cfg.Email == "" && !canPrompt() {
+		errs = append(errs, errors.New("No email address."))
+	}
+	switch {
+	case cfg.CookiesPath != "":
+		// No password needed.
+	case cfg.PasswordFile != "":
+		_, err := readPasswordFile(cfg.PasswordFile)
+		if err != nil {
+			errs = append(errs, errors.New("Bad password file.\n"+err.Error()))
+		}
+	case cfg.Password == "" && cfg.PasswordCommand == "" && !canPrompt():
+		errs = append(errs, errors.New("No password, password command or password file."))
+	}
+	templates := []string{cfg.AlbumTemplate, cfg.TrackTemplate}
+	for name, profile := range cfg.Profiles {
+		if profile == nil {
+			errs = append(errs, errors.New("Profile "+name+" is empty."))
+			continue
+		}
+		templates = append(templates, profile.AlbumTemplate, profile.TrackTemplate)
+	}
+	for _, tmpl := range templates {
+		_, err := template.New("").Parse(tmpl)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid template %s\n%s", tmpl, err))
+		}
+	}
+	if len(cfg.Watch.Labels) > 0 || len(cfg.Watch.Artists) > 0 {
+		_, err := getWatchSources(cfg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, _url := range []string{cfg.BaseUrl, cfg.ApiUrl} {
+		if _url == "" {
+			continue
+		}
+		parsed, err := url.Parse(_url)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, errors.New("Invalid URL: "+_url))
+		}
+	}
+	if cfg.Daemon.Listen != "" {
+		_, _, err := net.SplitHostPort(cfg.Daemon.Listen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid daemon listen address.\n%s", err))
+		} else if !isLoopback(cfg.Daemon.Listen) && cfg.Daemon.Token == "" {
+			errs = append(errs, errors.New("daemon.listen isn't a loopback address and no daemon.token is set."))
+		}
+	}
+	return errs
+}
+
+// config show|validate. Settings are merged just as they would be for a download.
+func runConfigCmd() error {
+	var args ConfigArgs
+	err := parseSubArgs("config", &args)
+	if err != nil {
+		return err
+	}
+	if args.Action != "show" && args.Action != "validate" {
+		return configErr("Unknown config action: "+args.Action, errors.New("Must be show or validate."))
+	}
+	cfg, err := readConfig(args.Config, args.Profile)
+	if err != nil {
+		return configErr("Failed to parse config file.", err)
+	}
+	applyArgs(cfg, &args)
+	err = setCfgDefaults(cfg)
+	if err != nil {
+		return configErr("Failed to parse config file.", err)
+	}
+	if args.Action == "show" {
+		return showConfig(cfg)
+	}
+	errs := validateConfig(cfg)
+	if len(errs) == 0 {
+		fmt.Println("Config is valid.")
+		return nil
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	return configErr(fmt.Sprintf("Config has %d problem(s).", len(errs)), nil)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetEnvName(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"Email", "EMAIL"},
+		{"OutPath", "OUT_PATH"},
+		{"OmitOrigMix", "OMIT_ORIG_MIX"},
+		{"BaseUrl", "BASE_URL"},
+		// Runs of capitals stay together.
+		{"JSON", "JSON"},
+		{"ID", "ID"},
+	}
+	for _, tt := range tests {
+		got := getEnvName(tt.field)
+		if got != tt.want {
+			t.Errorf("getEnvName(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(cfg *Config) bool
+		wantErr string
+	}{
+		{
+			name:  "string",
+			env:   map[string]string{"BP_OUT_PATH": "/music"},
+			check: func(cfg *Config) bool { return cfg.OutPath == "/music" },
+		},
+		{
+			name:  "bool turned off",
+			env:   map[string]string{"BP_MAX_COVER": "false"},
+			check: func(cfg *Config) bool { return !cfg.MaxCover },
+		},
+		{
+			name:  "int set to 0",
+			env:   map[string]string{"BP_REDOWNLOADS": "0"},
+			check: func(cfg *Config) bool { return cfg.Redownloads == 0 },
+		},
+		{
+			name: "list split on semicolons",
+			env:  map[string]string{"BP_FILTERS": "bpm=125-130; genre=Techno, Minimal ;;"},
+			check: func(cfg *Config) bool {
+				return reflect.DeepEqual(cfg.Filters, []string{"bpm=125-130", "genre=Techno, Minimal"})
+			},
+		},
+		{
+			name: "nested",
+			env:  map[string]string{"BP_WATCH_INTERVAL": "5", "BP_DAEMON_JOBS_PATH": "/var/jobs.json"},
+			check: func(cfg *Config) bool {
+				return cfg.Watch.Interval == 5 && cfg.Daemon.JobsPath == "/var/jobs.json"
+			},
+		},
+		{
+			name:  "empty string still counts",
+			env:   map[string]string{"BP_EMAIL": ""},
+			check: func(cfg *Config) bool { return cfg.Email == "" },
+		},
+		{
+			name: "unset leaves the config file's",
+			env:  map[string]string{},
+			check: func(cfg *Config) bool {
+				return cfg.Email == "a@example.com" && cfg.MaxCover && cfg.Redownloads == 2
+			},
+		},
+		// Only settable by args.
+		{
+			name:  "not from env",
+			env:   map[string]string{"BP_JSON": "true", "BP_RECORD_PATH": "x.ndjson"},
+			check: func(cfg *Config) bool { return !cfg.JSON && cfg.RecordPath == "" },
+		},
+		{
+			name:    "bad bool",
+			env:     map[string]string{"BP_SIDECAR": "yes please"},
+			wantErr: "Invalid BP_SIDECAR",
+		},
+		{
+			name:    "bad int",
+			env:     map[string]string{"BP_QUALITY": "high"},
+			wantErr: "Invalid BP_QUALITY",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg := &Config{Email: "a@example.com", MaxCover: true, Redownloads: 2}
+			err := applyEnv(reflect.ValueOf(cfg).Elem(), envPrefix)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("got %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	dir := t.TempDir()
+	xdgPath := filepath.Join(dir, appName, cfgFname)
+	err := makeDirs(filepath.Dir(xdgPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(xdgPath, []byte("{}"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("BP_CONFIG", "")
+	tests := []struct {
+		name string
+		arg  string
+		env  string
+		want string
+	}{
+		{"found in XDG_CONFIG_HOME", "", "", xdgPath},
+		{"BP_CONFIG", "", "env.json", "env.json"},
+		{"--config over BP_CONFIG", "arg.json", "env.json", "arg.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BP_CONFIG", tt.env)
+			got, err := findConfig(tt.arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		check func(cfg *Config) bool
+	}{
+		{
+			name: "not given leaves the config",
+			check: func(cfg *Config) bool {
+				return cfg.OutPath == "/music" && cfg.MaxCover && cfg.Redownloads == 2 && cfg.Watch.Interval == 30
+			},
+		},
+		{
+			name:  "bool turned off",
+			flags: []string{"--maxcover=false"},
+			check: func(cfg *Config) bool { return !cfg.MaxCover },
+		},
+		{
+			name:  "bool turned on",
+			flags: []string{"-s"},
+			check: func(cfg *Config) bool { return cfg.Sidecar },
+		},
+		{
+			name:  "int set to 0",
+			flags: []string{"-r", "0"},
+			check: func(cfg *Config) bool { return cfg.Redownloads == 0 },
+		},
+		{
+			name:  "cfg tag",
+			flags: []string{"-i", "5", "--label", "1", "--label", "2"},
+			check: func(cfg *Config) bool {
+				return cfg.Watch.Interval == 5 && reflect.DeepEqual(cfg.Watch.Labels, []string{"1", "2"})
+			},
+		},
+		{
+			name:  "string",
+			flags: []string{"-o", "/other"},
+			check: func(cfg *Config) bool { return cfg.OutPath == "/other" },
+		},
+		// Picked before the config's read, and only kept on the args.
+		{
+			name:  "not config",
+			flags: []string{"--profile", "sam", "--mark-seen"},
+			check: func(cfg *Config) bool { return cfg.Profile == "" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args WatchArgs
+			err := parseArgsInto("watch", tt.flags, &args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &Config{OutPath: "/music", MaxCover: true, Redownloads: 2, Watch: WatchConfig{Interval: 30}}
+			applyArgs(cfg, &args)
+			if !tt.check(cfg) {
+				t.Errorf("got %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Email: "main@example.com", Password: "main-pass", CookiesPath: "main-cookies.txt",
+			OutPath: "/music", AlbumTemplate: "{{.album}}",
+			Profiles: map[string]*Profile{
+				"sam":     {Email: "sam@example.com", PasswordFile: "sam-pass.txt", OutPath: "/sam"},
+				"layout":  {TrackTemplate: "{{.title}}"},
+				"cookies": {CookiesPath: "other-cookies.txt"},
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		profile string
+		want    Config
+		wantErr bool
+	}{
+		// None of the top level credentials are kept, or main's password would be tried with sam's email.
+		{
+			name:    "credentials swapped as a set",
+			profile: "sam",
+			want: Config{
+				Email: "sam@example.com", PasswordFile: "sam-pass.txt", OutPath: "/sam", AlbumTemplate: "{{.album}}",
+			},
+		},
+		{
+			name:    "credentials inherited",
+			profile: "layout",
+			want: Config{
+				Email: "main@example.com", Password: "main-pass", CookiesPath: "main-cookies.txt",
+				OutPath: "/music", AlbumTemplate: "{{.album}}", TrackTemplate: "{{.title}}",
+			},
+		},
+		{
+			name:    "cookies count as credentials",
+			profile: "cookies",
+			want:    Config{CookiesPath: "other-cookies.txt", OutPath: "/music", AlbumTemplate: "{{.album}}"},
+		},
+		{name: "unknown", profile: "nobody", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base()
+			err := applyProfile(cfg, tt.profile)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Profiles = nil
+			if !reflect.DeepEqual(*cfg, tt.want) {
+				t.Errorf("got %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
+
+// Config file, then the profile, then env vars.
+func TestReadConfigProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cfgFname)
+	data := `{
+		"email": "main@example.com", "outPath": "/music", "profile": "sam",
+		"profiles": {"sam": {"email": "sam@example.com", "outPath": "/sam"}, "alex": {"outPath": "/alex"}}
+	}`
+	err := ioutil.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BP_PROFILE", "")
+	cfg, err := readConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "sam" || cfg.Email != "sam@example.com" || cfg.OutPath != "/sam" {
+		t.Errorf("default profile: got %+v", cfg)
+	}
+	t.Setenv("BP_PROFILE", "alex")
+	t.Setenv("BP_OUT_PATH", "/env")
+	cfg, err = readConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "alex" || cfg.Email != "main@example.com" || cfg.OutPath != "/env" {
+		t.Errorf("BP_PROFILE and BP_OUT_PATH: got %+v", cfg)
+	}
+	// --profile beats BP_PROFILE.
+	cfg, err = readConfig(path, "sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "sam" || cfg.Email != "sam@example.com" {
+		t.Errorf("--profile: got %+v", cfg)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Netscape cookies.txt, as exported by browser extensions and curl/yt-dlp:
+// domain, include subdomains, path, secure, expiry, name, value, tab separated.
+func parseCookies(data string) (map[string][]*http.Cookie, error) {
+	cookies := map[string][]*http.Cookie{}
+	now := time.Now()
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = line[len(httpOnlyPrefix):]
+		} else if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Line %d has %d fields, expected 7.", i+1, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d has an invalid expiry.\n%s", i+1, err)
+		}
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		// 0 means a session cookie.
+		if expiry != 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+			if cookie.Expires.Before(now) {
+				continue
+			}
+		}
+		domain := fields[0]
+		host := strings.TrimPrefix(domain, ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		cookies[host] = append(cookies[host], cookie)
+	}
+	return cookies, nil
+}
+
+// Only Beatport's own cookies are loaded, whatever else the export holds.
+func loadCookies(client *beatport.Client, cookiesPath string) error {
+	data, err := ioutil.ReadFile(cookiesPath)
+	if err != nil {
+		return err
+	}
+	cookies, err := parseCookies(string(data))
+	if err != nil {
+		return err
+	}
+	var loaded int
+	for host, hostCookies := range cookies {
+		if host != "beatport.com" && !strings.HasSuffix(host, ".beatport.com") {
+			continue
+		}
+		client.SetCookies(host, hostCookies)
+		loaded += len(hostCookies)
+	}
+	if loaded == 0 {
+		return errors.New("No unexpired Beatport cookies found.")
+	}
+	return nil
+}
+
+// Either a session from a signed in browser's cookies.txt, or the login form.
+func signIn(ctx context.Context, client *beatport.Client, cfg *Config) error {
+	if cfg.CookiesPath == "" {
+		return client.Login(ctx, cfg.Email, cfg.Password)
+	}
+	err := loadCookies(client, cfg.CookiesPath)
+	if err != nil {
+		return errors.New("Failed to load cookies.\n" + err.Error())
+	}
+	_, err = client.Subscription(ctx)
+	if err != nil {
+		return errors.New("Cookies don't hold a valid session. Export them again from a signed in browser.\n" + err.Error())
+	}
+	return nil
+}
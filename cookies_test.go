@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+func TestParseCookies(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// host: name=value flags, in order. Flags are d for domain, s for secure, h for HTTP only and e for an expiry.
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "comments, blank lines and CRLF",
+			data: "# Netscape HTTP Cookie File\r\n\r\n.beatport.com\tTRUE\t/\tTRUE\t0\tsessionid\tabc\r\n",
+			want: map[string][]string{"beatport.com": {"sessionid=abc ds"}},
+		},
+		{
+			name: "HTTP only",
+			data: "#HttpOnly_.beatport.com\tTRUE\t/\tFALSE\t0\tsessionid\tabc\n",
+			want: map[string][]string{"beatport.com": {"sessionid=abc dh"}},
+		},
+		{
+			name: "host only",
+			data: "www.beatport.com\tFALSE\t/\tFALSE\t0\tsessionid\tabc\n",
+			want: map[string][]string{"www.beatport.com": {"sessionid=abc "}},
+		},
+		{
+			name: "expired and unexpired",
+			data: ".beatport.com\tTRUE\t/\tFALSE\t1\told\tx\n.beatport.com\tTRUE\t/\tFALSE\t4102444800\tnew\ty\n",
+			want: map[string][]string{"beatport.com": {"new=y de"}},
+		},
+		{
+			name: "values with spaces and other hosts",
+			data: ".beatport.com\tTRUE\t/\tFALSE\t0\ta\tb c\n.example.com\tTRUE\t/\tFALSE\t0\td\te\n",
+			want: map[string][]string{"beatport.com": {"a=b c d"}, "example.com": {"d=e d"}},
+		},
+		{
+			name:    "space separated",
+			data:    "# comment\n.beatport.com TRUE / FALSE 0 sessionid abc\n",
+			wantErr: "Line 2 has 1 fields",
+		},
+		{
+			name:    "bad expiry",
+			data:    ".beatport.com\tTRUE\t/\tFALSE\tsoon\tsessionid\tabc\n",
+			wantErr: "Line 1 has an invalid expiry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := parseCookies(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string][]string{}
+			for host, hostCookies := range cookies {
+				for _, cookie := range hostCookies {
+					var flags string
+					if cookie.Domain != "" {
+						flags += "d"
+					}
+					if cookie.Secure {
+						flags += "s"
+					}
+					if cookie.HttpOnly {
+						flags += "h"
+					}
+					if !cookie.Expires.IsZero() {
+						flags += "e"
+					}
+					got[host] = append(got[host], cookie.Name+"="+cookie.Value+" "+flags)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for host, want := range tt.want {
+				if strings.Join(got[host], ", ") != strings.Join(want, ", ") {
+					t.Errorf("%s: got %v, want %v", host, got[host], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCookies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.txt")
+	data := ".beatport.com\tTRUE\t/\tTRUE\t0\tsessionid\tabc\n.example.com\tTRUE\t/\tTRUE\t0\tother\txyz\n"
+	err := ioutil.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := beatport.NewClient()
+	err = loadCookies(client, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		host string
+		want int
+	}{{"www.beatport.com", 1}, {"example.com", 0}} {
+		got := client.HTTPClient.Jar.Cookies(&url.URL{Scheme: "https", Host: tt.host, Path: "/"})
+		if len(got) != tt.want {
+			t.Errorf("%s: got cookies %v, want %d", tt.host, got, tt.want)
+		}
+	}
+
+	// Nothing for Beatport.
+	err = ioutil.WriteFile(path, []byte(".example.com\tTRUE\t/\tTRUE\t0\tother\txyz\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadCookies(beatport.NewClient(), path)
+	if err == nil {
+		t.Fatal("got no error for a file without Beatport cookies")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func readLine() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Runs a password manager's CLI, e.g. "pass show beatport" or "op read op://Private/Beatport/password".
+// Its first line of output is the password.
+func runPasswordCommand(command string) (string, error) {
+	var outBuffer bytes.Buffer
+	cmd := shellCommand(command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &outBuffer
+	// Lets helpers ask for a master password or PIN.
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", err
+	}
+	password := strings.SplitN(outBuffer.String(), "\n", 2)[0]
+	password = strings.TrimRight(password, "\r")
+	if password == "" {
+		return "", errors.New("Command printed nothing.")
+	}
+	return password, nil
+}
+
+func readPasswordFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	err = checkCredFile(info)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	password := strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r")
+	if password == "" {
+		return "", errors.New("File is empty.")
+	}
+	return password, nil
+}
+
+func canPrompt() bool {
+	return isTerminal(os.Stdin)
+}
+
+// Tried in order: password (config, BP_PASSWORD or --password), passwordCommand, passwordFile,
+// then a prompt if there's a terminal to ask on. The email's prompted for too if it's missing.
+func getCredentials(cfg *Config) error {
+	var err error
+	if cfg.Email == "" {
+		if !canPrompt() {
+			return errors.New("No email address.")
+		}
+		fmt.Fprint(os.Stderr, "Email: ")
+		cfg.Email, err = readLine()
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case cfg.Password != "":
+		return nil
+	case cfg.PasswordCommand != "":
+		cfg.Password, err = runPasswordCommand(cfg.PasswordCommand)
+		if err != nil {
+			return errors.New("Failed to run password command.\n" + err.Error())
+		}
+	case cfg.PasswordFile != "":
+		cfg.Password, err = readPasswordFile(cfg.PasswordFile)
+		if err != nil {
+			return errors.New("Failed to read password file.\n" + err.Error())
+		}
+	case canPrompt():
+		fmt.Fprint(os.Stderr, "Password: ")
+		cfg.Password, err = readHidden()
+		if err != nil {
+			return errors.New("Failed to read password.\n" + err.Error())
+		}
+	default:
+		return errors.New("No password. Set password, passwordCommand or passwordFile, or run from a terminal to be asked for it.")
+	}
+	if cfg.Password == "" {
+		return errors.New("No password.")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("sh", "-c", command)
+}
+
+func setEcho(on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// Echo's turned back on if Ctrl+C is pressed mid-prompt, otherwise the terminal's left without it.
+func readHidden() (string, error) {
+	err := setEcho(false)
+	if err != nil {
+		return "", err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigs:
+			setEcho(true)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		signal.Stop(sigs)
+		setEcho(true)
+		fmt.Fprintln(os.Stderr)
+	}()
+	return readLine()
+}
+
+// Anyone but the owner being able to read it defeats the point of keeping it out of the config.
+func checkCredFile(info os.FileInfo) error {
+	perm := info.Mode().Perm()
+	if perm&0077 != 0 {
+		return fmt.Errorf("Permissions are %04o, it must only be accessible by its owner. Run chmod 600 on it.", perm)
+	}
+	return nil
+}
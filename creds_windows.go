@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const enableEchoInput = 0x0004
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+func readHidden() (string, error) {
+	handle := syscall.Handle(os.Stdin.Fd())
+	var mode uint32
+	err := syscall.GetConsoleMode(handle, &mode)
+	if err != nil {
+		return "", err
+	}
+	ok, _, err := setConsoleMode.Call(uintptr(handle), uintptr(mode&^enableEchoInput))
+	if ok == 0 {
+		return "", err
+	}
+	defer func() {
+		setConsoleMode.Call(uintptr(handle), uintptr(mode))
+		fmt.Fprintln(os.Stderr)
+	}()
+	return readLine()
+}
+
+// NTFS ACLs don't map onto mode bits, so there's nothing useful to check.
+func checkCredFile(info os.FileInfo) error {
+	return nil
+}
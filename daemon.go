@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+const (
+	daemonListen   = "127.0.0.1:8420"
+	daemonJobsPath = "jobs.json"
+	// Finished jobs kept for the list, newest first. Older ones are dropped.
+	daemonKeepJobs = 100
+)
+
+func parseDaemonCfg() (*Config, *DaemonArgs, error) {
+	var args DaemonArgs
+	err := parseSubArgs("daemon", &args)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := readConfig(args.Config, args.Profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyArgs(cfg, &args)
+	err = setCfgDefaults(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Anyone who can reach the API can queue downloads.
+	if !isLoopback(cfg.Daemon.Listen) && cfg.Daemon.Token == "" {
+		return nil, nil, errors.New("daemon.token must be set to listen on " + cfg.Daemon.Listen + ", which isn't a loopback address.")
+	}
+	return cfg, &args, nil
+}
+
+// Other profiles are read and given their own client when a job first needs them.
+func (d *Daemon) getSession(profile string) (*Session, error) {
+	if profile == "" {
+		profile = d.cfg.Profile
+	}
+	session := d.sessions[profile]
+	if session != nil {
+		return session, nil
+	}
+	cfg, err := readConfig(d.args.Config, profile)
+	if err != nil {
+		return nil, err
+	}
+	applyArgs(cfg, d.args)
+	err = setCfgDefaults(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CookiesPath == "" {
+		err = getCredentials(cfg)
+		if err != nil {
+			return nil, errors.New("Failed to get credentials.\n" + err.Error())
+		}
+	}
+	err = makeDirs(cfg.OutPath)
+	if err != nil {
+		return nil, errors.New("Failed to make output path.\n" + err.Error())
+	}
+	session = &Session{cfg: cfg, client: newClient(cfg)}
+	d.sessions[profile] = session
+	return session, nil
+}
+
+// A new session signs in and has its subscription checked, an old one only re-auths if it's expired.
+func (d *Daemon) checkSession(ctx context.Context, session *Session) error {
+	if session.signedIn {
+		return checkSession(ctx, session.client, session.cfg)
+	}
+	err := signIn(ctx, session.client, session.cfg)
+	if err != nil {
+		return err
+	}
+	sub, err := session.client.Subscription(ctx)
+	if err != nil {
+		return errors.New("Failed to get subscription info.\n" + err.Error())
+	}
+	fmt.Println("Signed in to profile " + session.cfg.Profile + " - " + sub.PlanName())
+	err = checkPlan(sub, session.cfg)
+	if err != nil {
+		return err
+	}
+	session.signedIn = true
+	return nil
+}
+
+func (d *Daemon) hasProfile(profile string) bool {
+	_, ok := d.cfg.Profiles[profile]
+	return ok || profile == d.cfg.Profile
+}
+
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Without a token the daemon's on loopback, so any other Host means a DNS rebinding attack.
+func (d *Daemon) allowedHost(host string) bool {
+	if d.cfg.Daemon.Token != "" || host == d.cfg.Daemon.Listen {
+		return true
+	}
+	_, port, _ := net.SplitHostPort(d.cfg.Daemon.Listen)
+	for _, name := range []string{"localhost", "127.0.0.1", "[::1]"} {
+		if host == name+":"+port {
+			return true
+		}
+	}
+	return false
+}
+
+// Any web page the user visits can send requests to the API, so only ones from the daemon's own
+// page are let through. POSTs have to be JSON, which other sites can't send without a preflight.
+// The token can be given as a query param too, for the page's cover images.
+func (d *Daemon) guard(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.allowedHost(r.Host) {
+			d.writeError(w, http.StatusForbidden, "Host not allowed: "+r.Host)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		if origin != "" && origin != "http://"+r.Host {
+			d.writeError(w, http.StatusForbidden, "Origin not allowed: "+origin)
+			return
+		}
+		if d.cfg.Daemon.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				token = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(d.cfg.Daemon.Token)) != 1 {
+				d.writeError(w, http.StatusUnauthorized, "Missing or wrong token.")
+				return
+			}
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				d.writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json.")
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func (d *Daemon) writeJson(w http.ResponseWriter, status int, obj func() interface{}) {
+	data, err := d.jobs.marshal(obj)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (d *Daemon) writeError(w http.ResponseWriter, status int, errString string) {
+	d.writeJson(w, status, func() interface{} {
+		return map[string]string{"error": errString}
+	})
+}
+
+func (d *Daemon) enqueue(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Urls    []string `json:"urls"`
+		Profile string   `json:"profile"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		d.writeError(w, http.StatusBadRequest, "Invalid request body. "+err.Error())
+		return
+	}
+	if len(body.Urls) == 0 {
+		d.writeError(w, http.StatusBadRequest, "No URLs given.")
+		return
+	}
+	if body.Profile == "" {
+		body.Profile = d.cfg.Profile
+	}
+	if !d.hasProfile(body.Profile) {
+		d.writeError(w, http.StatusBadRequest, "No such profile: "+body.Profile)
+		return
+	}
+	for _, _url := range body.Urls {
+		if checkUrl(_url) == "" {
+			d.writeError(w, http.StatusBadRequest, "Invalid URL: "+_url)
+			return
+		}
+	}
+	var added []*Job
+	for _, _url := range body.Urls {
+		added = append(added, d.jobs.add(_url, body.Profile))
+	}
+	d.wakeWorker()
+	d.writeJson(w, http.StatusCreated, func() interface{} {
+		return added
+	})
+}
+
+func (d *Daemon) wakeWorker() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// GET lists jobs, POST enqueues {"urls": [...], "profile": "..."}. The profile's optional.
+func (d *Daemon) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	// Logs are left out, as the page polls this. They're at /api/jobs/{id}/log.
+	case http.MethodGet:
+		d.writeJson(w, http.StatusOK, func() interface{} {
+			jobs := make([]Job, len(d.jobs.Jobs))
+			for i, job := range d.jobs.Jobs {
+				jobs[i] = *job
+				jobs[i].Log = nil
+			}
+			return jobs
+		})
+	case http.MethodPost:
+		d.enqueue(w, r)
+	default:
+		d.writeError(w, http.StatusMethodNotAllowed, r.Method+" not allowed.")
+	}
+}
+
+// Names of the profiles jobs can be queued for, and the one used when none's given.
+func (d *Daemon) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		d.writeError(w, http.StatusMethodNotAllowed, r.Method+" not allowed.")
+		return
+	}
+	names := []string{}
+	for name := range d.cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	d.writeJson(w, http.StatusOK, func() interface{} {
+		return map[string]interface{}{"default": d.cfg.Profile, "profiles": names}
+	})
+}
+
+// /api/jobs/{id} and its cancel, retry, log and cover actions.
+func (d *Daemon) handleJob(w http.ResponseWriter, r *http.Request) {
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	id, err := strconv.Atoi(split[0])
+	if err != nil || len(split) > 2 {
+		d.writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	job := d.jobs.get(id)
+	if job == nil {
+		d.writeError(w, http.StatusNotFound, "No such job.")
+		return
+	}
+	action := ""
+	if len(split) == 2 {
+		action = split[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		d.writeJson(w, http.StatusOK, func() interface{} {
+			return job
+		})
+	case action == "log" && r.Method == http.MethodGet:
+		d.writeJson(w, http.StatusOK, func() interface{} {
+			return map[string][]string{"log": job.Log}
+		})
+	case action == "cancel" && r.Method == http.MethodPost:
+		job.cancel()
+		d.writeJson(w, http.StatusOK, func() interface{} {
+			return job
+		})
+	case action == "retry" && r.Method == http.MethodPost:
+		if !job.retry() {
+			d.writeError(w, http.StatusConflict, "Only failed or cancelled jobs can be retried.")
+			return
+		}
+		d.wakeWorker()
+		d.writeJson(w, http.StatusOK, func() interface{} {
+			return job
+		})
+	case action == "cover" && r.Method == http.MethodGet:
+		d.serveCover(w, r, job)
+	default:
+		d.writeError(w, http.StatusNotFound, "Not found.")
+	}
+}
+
+func (d *Daemon) runJob(job *Job) {
+	job.setStatus(jobRunning, nil)
+	job.log("Job", job.ID, "-", job.Url)
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	job.setCancelFunc(cancel)
+	defer job.setCancelFunc(nil)
+	session, err := d.getSession(job.Profile)
+	if err != nil {
+		err = errors.New("Failed to load profile.\n" + err.Error())
+	} else {
+		err = d.checkSession(ctx, session)
+		if ctx.Err() != nil {
+			err = interruptErr(job)
+		} else if err != nil {
+			err = errors.New("Failed to auth.\n" + err.Error())
+		} else {
+			err = d.processAlbum(ctx, session, job)
+		}
+	}
+	if err == nil {
+		err = job.trackErr()
+	}
+	switch {
+	case err == nil:
+		job.setStatus(jobDone, nil)
+	// Requeued so it's resumed when the daemon next starts.
+	case err == errInterrupted:
+		job.log(err)
+		job.setStatus(jobQueued, nil)
+	case err == errJobCancelled:
+		job.log(err)
+		job.setStatus(jobCancelled, nil)
+	default:
+		job.log(err)
+		job.setStatus(jobFailed, err)
+	}
+}
+
+// A panic, e.g. on metadata we didn't expect, only fails the job it came from, not the whole queue.
+func (d *Daemon) processAlbum(ctx context.Context, session *Session, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			job.log(string(debug.Stack()))
+			err = fmt.Errorf("Crashed: %v", r)
+		}
+	}()
+	_, err = processAlbum(ctx, session.client, session.cfg, d.tempPath, checkUrl(job.Url), job.Url, job)
+	return err
+}
+
+// Jobs run one at a time as they all share the one temp dir.
+func (d *Daemon) work() {
+	defer close(d.done)
+	for !isStopping() {
+		job := d.jobs.next()
+		if job == nil {
+			select {
+			case <-d.wake:
+			case <-stopping:
+			}
+			continue
+		}
+		d.runJob(job)
+		d.jobs.prune(daemonKeepJobs)
+	}
+}
+
+// The first Ctrl+C stops the HTTP server and lets the current track finish, see handleSignals.
+func runDaemon(ctx context.Context, client *beatport.Client, cfg *Config, args *DaemonArgs, tempPath string) error {
+	jobs, err := newJobStore(cfg.Daemon.JobsPath)
+	if err != nil {
+		return fatalErr("Failed to read jobs.", err)
+	}
+	defer jobs.close()
+	jobs.prune(daemonKeepJobs)
+	d := &Daemon{
+		ctx:  ctx,
+		cfg:  cfg,
+		args: args,
+		// Already signed in by run.
+		sessions: map[string]*Session{cfg.Profile: {cfg: cfg, client: client, signedIn: true}},
+		covers:   newClient(cfg),
+		tempPath: tempPath,
+		jobs:     jobs,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go d.work()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", d.guard(d.handleJobs))
+	mux.HandleFunc("/api/jobs/", d.guard(d.handleJob))
+	mux.HandleFunc("/api/profiles", d.guard(d.handleProfiles))
+	mux.Handle("/", webHandler())
+	srv := &http.Server{Addr: cfg.Daemon.Listen, Handler: mux}
+	go func() {
+		<-stopping
+		srv.Shutdown(context.Background())
+	}()
+	if cfg.Daemon.Token != "" {
+		fmt.Println("Listening on http://" + cfg.Daemon.Listen + "/#token=<daemon.token>")
+	} else {
+		fmt.Println("Listening on http://" + cfg.Daemon.Listen + "/")
+	}
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return fatalErr("HTTP server stopped.", err)
+	}
+	<-d.done
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		listen string
+		want   bool
+	}{
+		{"127.0.0.1:8420", true},
+		{"localhost:8420", true},
+		{"[::1]:8420", true},
+		{"127.0.0.2:8420", true},
+		{"0.0.0.0:8420", false},
+		{":8420", false},
+		{"192.168.1.2:8420", false},
+		{"nas.local:8420", false},
+		// No port.
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		got := isLoopback(tt.listen)
+		if got != tt.want {
+			t.Errorf("isLoopback(%q) = %v, want %v", tt.listen, got, tt.want)
+		}
+	}
+}
+
+func TestDaemonGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       string
+		method      string
+		host        string
+		target      string
+		origin      string
+		auth        string
+		contentType string
+		want        int
+	}{
+		{name: "listen address", host: "127.0.0.1:8420", want: http.StatusNoContent},
+		{name: "localhost", host: "localhost:8420", want: http.StatusNoContent},
+		{name: "IPv6 loopback", host: "[::1]:8420", want: http.StatusNoContent},
+		{name: "DNS rebinding", host: "evil.example:8420", want: http.StatusForbidden},
+		{name: "other port", host: "127.0.0.1:8421", want: http.StatusForbidden},
+		{name: "own page", host: "127.0.0.1:8420", origin: "http://127.0.0.1:8420", want: http.StatusNoContent},
+		{name: "other origin", host: "127.0.0.1:8420", origin: "http://evil.example", want: http.StatusForbidden},
+		{
+			name: "JSON POST", method: http.MethodPost, host: "127.0.0.1:8420",
+			contentType: "application/json; charset=utf-8", want: http.StatusNoContent,
+		},
+		// What a cross-site form or a fetch without a preflight can send.
+		{
+			name: "form POST", method: http.MethodPost, host: "127.0.0.1:8420",
+			contentType: "application/x-www-form-urlencoded", want: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "text POST", method: http.MethodPost, host: "127.0.0.1:8420",
+			contentType: "text/plain", want: http.StatusUnsupportedMediaType,
+		},
+		{name: "POST without a type", method: http.MethodPost, host: "127.0.0.1:8420", want: http.StatusUnsupportedMediaType},
+		{name: "token missing", token: "t0ken", host: "127.0.0.1:8420", want: http.StatusUnauthorized},
+		{name: "token wrong", token: "t0ken", host: "127.0.0.1:8420", auth: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "token header", token: "t0ken", host: "nas.local:8420", auth: "Bearer t0ken", want: http.StatusNoContent},
+		{name: "token param", token: "t0ken", host: "nas.local:8420", target: "/api/jobs?token=t0ken", want: http.StatusNoContent},
+		{
+			name: "token from another origin", token: "t0ken", host: "nas.local:8420", origin: "http://evil.example",
+			auth: "Bearer t0ken", want: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, _ := newJobStore("")
+			d := &Daemon{
+				cfg:  &Config{Daemon: DaemonConfig{Listen: "127.0.0.1:8420", Token: tt.token}},
+				jobs: jobs,
+			}
+			h := d.guard(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			method, target := tt.method, tt.target
+			if method == "" {
+				method = http.MethodGet
+			}
+			if target == "" {
+				target = "/api/jobs"
+			}
+			req := httptest.NewRequest(method, target, nil)
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Package decrypt handles the AES-128-CBC encryption Beatport's HLS segments are wrapped in.
+package decrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrKeySize        = errors.New("Invalid key size.")
+	ErrIVSize         = errors.New("Invalid IV size.")
+	ErrCiphertextSize = errors.New("Invalid ciphertext size.")
+	ErrPadding        = errors.New("Invalid PKCS#7 padding.")
+)
+
+// HLS only defines AES-128, so anything other than a 16-byte key is rejected.
+func checkSizes(ciphertext, key, iv []byte) error {
+	if len(key) != 16 {
+		return fmt.Errorf("%w Expected 16 bytes, got %d.", ErrKeySize, len(key))
+	}
+	if len(iv) != aes.BlockSize {
+		return fmt.Errorf("%w Expected %d bytes, got %d.", ErrIVSize, aes.BlockSize, len(iv))
+	}
+	if len(ciphertext) == 0 {
+		return fmt.Errorf("%w Segment is empty.", ErrCiphertextSize)
+	}
+	if len(ciphertext)%aes.BlockSize != 0 {
+		return fmt.Errorf(
+			"%w %d bytes isn't a multiple of the %d-byte block size, segment may be truncated.",
+			ErrCiphertextSize, len(ciphertext), aes.BlockSize,
+		)
+	}
+	return nil
+}
+
+// CBC decrypts without touching the padding.
+func CBC(ciphertext, key, iv []byte) ([]byte, error) {
+	err := checkSizes(ciphertext, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, ciphertext)
+	return decrypted, nil
+}
+
+// Unpad strips PKCS#7 padding, checking every padding byte rather than trusting the last one.
+func Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, fmt.Errorf("%w Data is %d bytes.", ErrPadding, len(data))
+	}
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > blockSize {
+		return nil, fmt.Errorf("%w Padding length %d is out of range.", ErrPadding, padding)
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, fmt.Errorf("%w Padding bytes don't match, wrong key or IV?", ErrPadding)
+		}
+	}
+	return data[:len(data)-padding], nil
+}
+
+// Segment decrypts a whole segment and strips its padding.
+func Segment(ciphertext, key, iv []byte) ([]byte, error) {
+	decrypted, err := CBC(ciphertext, key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return Unpad(decrypted, aes.BlockSize)
+}
@@ -0,0 +1,126 @@
+package decrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+const (
+	// NIST SP 800-38A, F.2.5 CBC-AES128.Decrypt.
+	nistKey = "2b7e151628aed2a6abf7158809cf4f3c"
+	nistIV  = "000102030405060708090a0b0c0d0e0f"
+	nistCt  = "7649abac8119b246cee98e9b12e9197d" +
+		"5086cb9b507219ee95db113a917678b2" +
+		"73bed6b8e3c1743b7116e69e22229516" +
+		"3ff1caa1681fac09120eca307586e1a7"
+	nistPt = "6bc1bee22e409f96e93d7e117393172a" +
+		"ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" +
+		"f69f2445df4f9b17ad2b417be66c3710"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCBC(t *testing.T) {
+	tests := []struct {
+		name    string
+		ct      string
+		key     string
+		iv      string
+		want    string
+		wantErr error
+	}{
+		{"nist", nistCt, nistKey, nistIV, nistPt, nil},
+		{"nist first block", nistCt[:32], nistKey, nistIV, nistPt[:32], nil},
+		{"short key", nistCt, nistKey[:30], nistIV, "", ErrKeySize},
+		{"aes-256 key", nistCt, nistKey + nistKey, nistIV, "", ErrKeySize},
+		{"short iv", nistCt, nistKey, nistIV[:30], "", ErrIVSize},
+		{"long iv", nistCt, nistKey, nistIV + "00", "", ErrIVSize},
+		{"empty", "", nistKey, nistIV, "", ErrCiphertextSize},
+		{"truncated", nistCt[:62], nistKey, nistIV, "", ErrCiphertextSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CBC(unhex(t, tt.ct), unhex(t, tt.key), unhex(t, tt.iv))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, unhex(t, tt.want)) {
+				t.Fatalf("got %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	full := bytes.Repeat([]byte{16}, 16)
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr error
+	}{
+		{"one byte", append([]byte("fifteen bytes!!"), 1), []byte("fifteen bytes!!"), nil},
+		{"eight bytes", append([]byte("Beatport"), bytes.Repeat([]byte{8}, 8)...), []byte("Beatport"), nil},
+		{"full block", append([]byte("0123456789abcdef"), full...), []byte("0123456789abcdef"), nil},
+		{"only padding", full, []byte{}, nil},
+		{"zero", append([]byte("fifteen bytes!!"), 0), nil, ErrPadding},
+		{"too long", append([]byte("fifteen bytes!!"), 17), nil, ErrPadding},
+		{"mismatched", append([]byte("abcdefghijk1234"), 5), nil, ErrPadding},
+		{"empty", nil, nil, ErrPadding},
+		{"not block sized", []byte{1}, nil, ErrPadding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unpad(tt.data, 16)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		ct      string
+		want    string
+		wantErr error
+	}{
+		{"short", "b4f01000556508c5573ba40fd346cc7b", "Beatport", nil},
+		{
+			"full padding block",
+			"64768548007aef9f3d258e5c34cdc21bde0a1268436e159434fc21de3696d928",
+			"0123456789abcdef", nil,
+		},
+		// Raw blocks ending in bad padding, encrypted with the NIST key and IV.
+		{"zero padding", "3fec36dae3d509dd1c03b0e5f7326fba", "", ErrPadding},
+		{"mismatched padding", "470df7e246b4a20fdc61cb1e782d2b67", "", ErrPadding},
+		// No PKCS#7 padding on the NIST plaintext, its last byte is 0x10.
+		{"unpadded", nistCt, "", ErrPadding},
+		{"truncated", nistCt[:40], "", ErrCiphertextSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Segment(unhex(t, tt.ct), unhex(t, nistKey), unhex(t, nistIV))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(got) != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	eventRunStarted      = "run_started"
+	eventRunFinished     = "run_finished"
+	eventReleaseStarted  = "release_started"
+	eventTrackSkipped    = "track_skipped"
+	eventSegmentProgress = "segment_progress"
+	eventTrackDone       = "track_done"
+	eventError           = "error"
+)
+
+var (
+	// Nil unless --json was given.
+	events   *json.Encoder
+	eventsMu sync.Mutex
+)
+
+// Events get the real stdout to themselves, everything printed for humans goes to stderr instead.
+func setupEvents() {
+	events = json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+}
+
+func emit(event *Event) {
+	if events == nil {
+		return
+	}
+	event.Time = time.Now()
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	err := events.Encode(event)
+	if err != nil {
+		handleErr("Failed to write event.", err)
+	}
+}
+
+func emitError(context string, err error) {
+	emit(&Event{Event: eventError, Context: context, Error: err.Error()})
+}
+
+// Fills in the release and track a job event is about.
+func (j *Job) emit(event *Event, trackNum int) {
+	j.store.mu.Lock()
+	event.Url = j.Url
+	event.Album = j.Album
+	if trackNum > 0 && trackNum <= len(j.Tracks) {
+		track := j.Tracks[trackNum-1]
+		event.Track = track.Num
+		event.TrackTotal = len(j.Tracks)
+		event.TrackID = track.ID
+		event.Title = track.Title
+	}
+	j.store.mu.Unlock()
+	emit(event)
+}
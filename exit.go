@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alexflint/go-arg"
+)
+
+// Process exit codes, so scripts can tell what went wrong without scraping the output.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitConfig      = 2
+	exitAuth        = 3
+	exitNoSub       = 4
+	exitPartial     = 5
+	exitInterrupted = 130
+)
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	return e.Msg + "\n" + e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func fatalErr(msg string, err error) error {
+	return &ExitError{Code: exitError, Msg: msg, Err: err}
+}
+
+func configErr(msg string, err error) error {
+	return &ExitError{Code: exitConfig, Msg: msg, Err: err}
+}
+
+// For errors that already know their exit code, e.g. bad args, or are help being shown, it's left alone.
+func wrapConfigErr(msg string, err error) error {
+	var exitErr *ExitError
+	if err == arg.ErrHelp || errors.As(err, &exitErr) {
+		return err
+	}
+	return configErr(msg, err)
+}
+
+func authErr(msg string, err error) error {
+	return &ExitError{Code: exitAuth, Msg: msg, Err: err}
+}
+
+func noSubErr(msg string) error {
+	return &ExitError{Code: exitNoSub, Msg: msg}
+}
+
+func exitCode(err error) int {
+	if err == nil || err == arg.ErrHelp {
+		return exitOK
+	}
+	if errors.Is(err, errInterrupted) {
+		return exitInterrupted
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return exitError
+}
+
+// Tallies a finished album's tracks. Albums that failed before any tracks were known count on their own.
+func (s *RunSummary) addJob(job *Job, err error) {
+	job.store.mu.Lock()
+	defer job.store.mu.Unlock()
+	if err != nil && err != errInterrupted && len(job.Tracks) == 0 {
+		s.FailedAlbums++
+		return
+	}
+	for _, track := range job.Tracks {
+		switch track.Status {
+		case trackTagged:
+			s.Downloaded++
+		case trackSkipped:
+			s.Skipped++
+		case trackFiltered:
+			s.Filtered++
+		case trackFailed:
+			s.Failed++
+		}
+	}
+}
+
+func (s *RunSummary) print() {
+	fmt.Printf(
+		"\nDownloaded: %d, skipped: %d, filtered: %d, failed: %d.\n",
+		s.Downloaded, s.Skipped, s.Filtered, s.Failed,
+	)
+	if s.FailedAlbums > 0 {
+		fmt.Printf("%d album(s) failed outright.\n", s.FailedAlbums)
+	}
+}
+
+func (s *RunSummary) err() error {
+	if s.Failed == 0 && s.FailedAlbums == 0 {
+		return nil
+	}
+	return &ExitError{
+		Code: exitPartial,
+		Msg:  fmt.Sprintf("%d track(s) and %d album(s) failed.", s.Failed, s.FailedAlbums),
+	}
+}
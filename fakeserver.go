@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Sorrow446/Beatport-Downloader/fakeserver"
+)
+
+// fakeserver serves the bundled fixtures so the whole download path can be tried offline.
+func runFakeServerCmd() error {
+	var args FakeServerArgs
+	err := parseSubArgs("fakeserver", &args)
+	if err != nil {
+		return err
+	}
+	srv, err := fakeserver.New()
+	if err != nil {
+		return fatalErr("Failed to load fixtures.", err)
+	}
+	base := "http://" + args.Listen + "/"
+	fmt.Println("Fake Beatport listening on " + base)
+	fmt.Printf("Sign in as %s with password %s, e.g.:\n", srv.Email, srv.Password)
+	fmt.Printf("%s --baseurl %s -e %s -p %s %srelease/offline-ep/1\n", os.Args[0], base, srv.Email, srv.Password, base)
+	err = http.ListenAndServe(args.Listen, srv)
+	return fatalErr("Fake server stopped.", err)
+}
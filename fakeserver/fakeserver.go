@@ -0,0 +1,397 @@
+// Package fakeserver is an offline stand-in for the parts of beatport.com the downloader uses:
+// the login form, subscriptions, releases, tracks, streams, HLS playlists, AES keys and encrypted
+// segments, all served from the fixtures folder. Point a beatport.Client's BaseURL at it.
+package fakeserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+const (
+	sampleRate = 44100
+	// Samples per AAC frame.
+	frameSamples = 1024
+	segmentSecs  = 4
+	sessionName  = "sessionid"
+)
+
+// A silent AAC-LC stereo 44.1 kHz frame, ADTS header included.
+var silentFrame = []byte{0xff, 0xf1, 0x50, 0x80, 0x02, 0x1f, 0xfc, 0x21, 0x00, 0x49, 0x90, 0x02, 0x19, 0x00, 0x23, 0x80}
+
+//go:embed fixtures
+var fixtures embed.FS
+
+type account struct {
+	Email        string          `json:"email"`
+	Password     string          `json:"password"`
+	Subscription json.RawMessage `json:"subscription"`
+}
+
+// Server is an http.Handler. Fixtures reference the server's own URL as {base}.
+type Server struct {
+	Email    string
+	Password string
+	mux      *http.ServeMux
+	sub      []byte
+	releases map[string][]byte
+	tracks   map[string][]byte
+	summary  []*releaseInfo
+	lengths  map[string]int
+	cover    []byte
+	mu       sync.Mutex
+	sessions map[string]bool
+}
+
+type releaseInfo struct {
+	summary   *beatport.ReleaseSummary
+	labelID   int
+	artistIDs []int
+}
+
+func readFixtures(dir string, f func(id string, data []byte) error) error {
+	entries, err := fs.ReadDir(fixtures, "fixtures/"+dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, err := fixtures.ReadFile("fixtures/" + dir + "/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		err = f(strings.TrimSuffix(entry.Name(), ".json"), data)
+		if err != nil {
+			return fmt.Errorf("%s/%s: %s", dir, entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// New loads the fixtures.
+func New() (*Server, error) {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		releases: map[string][]byte{},
+		tracks:   map[string][]byte{},
+		lengths:  map[string]int{},
+		sessions: map[string]bool{},
+	}
+	data, err := fixtures.ReadFile("fixtures/account.json")
+	if err != nil {
+		return nil, err
+	}
+	var acc account
+	err = json.Unmarshal(data, &acc)
+	if err != nil {
+		return nil, err
+	}
+	s.Email, s.Password, s.sub = acc.Email, acc.Password, acc.Subscription
+	err = readFixtures("releases", func(id string, data []byte) error {
+		var release beatport.Release
+		err := json.Unmarshal(data, &release)
+		if err != nil {
+			return err
+		}
+		info := &releaseInfo{
+			summary: &beatport.ReleaseSummary{
+				ID: release.ID, Name: release.Name, Slug: release.Slug, PublishDate: release.PublishDate,
+			},
+			labelID: release.Label.ID,
+		}
+		for _, artist := range release.Artists {
+			info.artistIDs = append(info.artistIDs, artist.ID)
+		}
+		s.releases[id] = data
+		s.summary = append(s.summary, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Newest first, as the list endpoint's asked for.
+	sort.Slice(s.summary, func(i, j int) bool {
+		return s.summary[i].summary.PublishDate > s.summary[j].summary.PublishDate
+	})
+	err = readFixtures("tracks", func(id string, data []byte) error {
+		var track beatport.Track
+		err := json.Unmarshal(data, &track)
+		if err != nil {
+			return err
+		}
+		s.tracks[id] = data
+		s.lengths[id] = track.LengthMs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.cover, err = makeCover()
+	if err != nil {
+		return nil, err
+	}
+	s.mux.HandleFunc("/account/login", s.handleLogin)
+	s.mux.HandleFunc("/api/v4/my/subscriptions", s.requireSession(s.handleSubscription))
+	s.mux.HandleFunc("/api/v4/catalog/releases/", s.handleReleases)
+	s.mux.HandleFunc("/api/v4/catalog/tracks/", s.handleTracks)
+	s.mux.HandleFunc("/stream/", s.requireSession(s.handleStream))
+	s.mux.HandleFunc("/key/", s.requireSession(s.handleKey))
+	s.mux.HandleFunc("/img/", s.handleImage)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+		}
+	})
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func getBase(r *http.Request) string {
+	return "http://" + r.Host + "/"
+}
+
+func writeFixture(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes.ReplaceAll(data, []byte("{base}"), []byte(getBase(r))))
+}
+
+func writeJson(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj)
+}
+
+func (s *Server) requireSession(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionName)
+		s.mu.Lock()
+		ok := err == nil && s.sessions[cookie.Value]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "Not signed in.", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Like the real form, bad credentials just render the login page again.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.SetCookie(w, &http.Cookie{Name: "_csrf_token", Value: "fake-csrf-token", Path: "/"})
+		return
+	}
+	r.ParseForm()
+	if r.PostForm.Get("_csrf_token") != "fake-csrf-token" ||
+		r.PostForm.Get("username") != s.Email || r.PostForm.Get("password") != s.Password {
+		return
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	session := hex.EncodeToString(buf)
+	s.mu.Lock()
+	s.sessions[session] = true
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{Name: sessionName, Value: session, Path: "/", HttpOnly: true})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (s *Server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	writeFixture(w, r, s.sub)
+}
+
+// /api/v4/catalog/releases/{id} or a label's or artist's releases by query.
+func (s *Server) handleReleases(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v4/catalog/releases/"), "/")
+	if id != "" {
+		data, ok := s.releases[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeFixture(w, r, data)
+		return
+	}
+	query := r.URL.Query()
+	labelID, _ := strconv.Atoi(query.Get("label_id"))
+	artistID, _ := strconv.Atoi(query.Get("artist_id"))
+	results := []*beatport.ReleaseSummary{}
+	for _, info := range s.summary {
+		match := labelID != 0 && info.labelID == labelID
+		for _, id := range info.artistIDs {
+			match = match || (artistID != 0 && id == artistID)
+		}
+		if match {
+			results = append(results, info.summary)
+		}
+	}
+	writeJson(w, &beatport.ReleaseList{Count: len(results), Page: "1/1", PerPage: 100, Results: results})
+}
+
+// /api/v4/catalog/tracks/{id} and /api/v4/catalog/tracks/{id}/stream.
+func (s *Server) handleTracks(w http.ResponseWriter, r *http.Request) {
+	split := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v4/catalog/tracks/"), "/"), "/")
+	data, ok := s.tracks[split[0]]
+	switch {
+	case !ok || len(split) > 2:
+		http.NotFound(w, r)
+	case len(split) == 1:
+		writeFixture(w, r, data)
+	case split[1] == "stream":
+		s.requireSession(func(w http.ResponseWriter, r *http.Request) {
+			end, _ := strconv.Atoi(r.URL.Query().Get("end"))
+			writeJson(w, &beatport.Stream{
+				StreamURL:   getBase(r) + "stream/" + split[0] + "/file.128k.aac.m3u8",
+				SampleEndMs: end,
+			})
+		})(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func getSegmentTotal(lengthMs int) int {
+	frames := getFrameTotal(lengthMs)
+	perSegment := segmentSecs * sampleRate / frameSamples
+	return (frames + perSegment - 1) / perSegment
+}
+
+func getFrameTotal(lengthMs int) int {
+	samples := lengthMs * sampleRate / 1000
+	return (samples + frameSamples - 1) / frameSamples
+}
+
+// /stream/{id}/file.{128,256}k.aac.m3u8 and /stream/{id}/seg/{n}.aac.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/stream/"), "/")
+	lengthMs, ok := s.lengths[split[0]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(split) == 2 && (split[1] == "file.128k.aac.m3u8" || split[1] == "file.256k.aac.m3u8"):
+		s.writePlaylist(w, split[0], lengthMs)
+	case len(split) == 3 && split[1] == "seg":
+		num, err := strconv.Atoi(strings.TrimSuffix(split[2], ".aac"))
+		if err != nil || num < 0 || num >= getSegmentTotal(lengthMs) {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := Segment(split[0], lengthMs, num)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/aac")
+		w.Write(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// No IV, so each segment's media sequence number is used.
+func (s *Server) writePlaylist(w http.ResponseWriter, trackID string, lengthMs int) {
+	var buf strings.Builder
+	segTotal := getSegmentTotal(lengthMs)
+	fmt.Fprintf(&buf, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", segmentSecs)
+	fmt.Fprintf(&buf, "#EXT-X-KEY:METHOD=AES-128,URI=\"/key/%s\"\n", trackID)
+	remaining := float64(lengthMs) / 1000
+	for i := 0; i < segTotal; i++ {
+		duration := float64(segmentSecs)
+		if remaining < duration {
+			duration = remaining
+		}
+		remaining -= duration
+		fmt.Fprintf(&buf, "#EXTINF:%.3f,\nseg/%d.aac\n", duration, i)
+	}
+	buf.WriteString("#EXT-X-ENDLIST\n")
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(buf.String()))
+}
+
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	trackID := strings.TrimPrefix(r.URL.Path, "/key/")
+	if _, ok := s.lengths[trackID]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(Key(trackID))
+}
+
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	if path.Ext(r.URL.Path) != ".jpg" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(s.cover)
+}
+
+func makeCover() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{0x01, 0xff, 0x95, 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	return buf.Bytes(), err
+}
+
+// Key is the AES-128 key a track's segments are encrypted with.
+func Key(trackID string) []byte {
+	sum := md5.Sum([]byte("fakeserver " + trackID))
+	return sum[:]
+}
+
+// Audio is segment num of a track lengthMs long, before encryption: silent ADTS frames.
+func Audio(lengthMs, num int) []byte {
+	perSegment := segmentSecs * sampleRate / frameSamples
+	frames := getFrameTotal(lengthMs) - num*perSegment
+	if frames > perSegment {
+		frames = perSegment
+	}
+	if frames < 0 {
+		frames = 0
+	}
+	return bytes.Repeat(silentFrame, frames)
+}
+
+// Segment is segment num of a track, PKCS#7 padded and encrypted with its key and sequence number IV.
+func Segment(trackID string, lengthMs, num int) ([]byte, error) {
+	block, err := aes.NewCipher(Key(trackID))
+	if err != nil {
+		return nil, err
+	}
+	data := Audio(lengthMs, num)
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(num))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data, nil
+}
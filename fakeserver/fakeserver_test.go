@@ -0,0 +1,165 @@
+package fakeserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/adts"
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+	"github.com/Sorrow446/Beatport-Downloader/decrypt"
+)
+
+func newClient(t *testing.T) (*Server, *beatport.Client) {
+	t.Helper()
+	srv, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpSrv := httptest.NewServer(srv)
+	t.Cleanup(httpSrv.Close)
+	c := beatport.NewClient()
+	c.BaseURL = httpSrv.URL + "/"
+	return srv, c
+}
+
+func get(t *testing.T, c *beatport.Client, _url string) []byte {
+	t.Helper()
+	resp, err := c.Get(context.Background(), _url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", _url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLogin(t *testing.T) {
+	srv, c := newClient(t)
+	ctx := context.Background()
+	_, err := c.Subscription(ctx)
+	var statusErr *beatport.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got error %v before signing in, want 401", err)
+	}
+	err = c.Login(ctx, srv.Email, "wrong")
+	if !errors.Is(err, beatport.ErrLogin) {
+		t.Fatalf("got error %v, want %v", err, beatport.ErrLogin)
+	}
+	err = c.Login(ctx, srv.Email, srv.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscription(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caps, known := sub.Caps()
+	if !known || !caps.Streaming || caps.MaxBitrate != 256 {
+		t.Fatalf("got %+v, %t", caps, known)
+	}
+}
+
+// Everything the downloader does short of muxing: release, tracks, stream, playlist, key and segments.
+func TestDownload(t *testing.T) {
+	srv, c := newClient(t)
+	ctx := context.Background()
+	err := c.Login(ctx, srv.Email, srv.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := c.Release(ctx, "1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(release.Tracks) != 2 || !strings.HasPrefix(release.Tracks[0], c.BaseURL) {
+		t.Fatalf("got tracks %v", release.Tracks)
+	}
+	track, err := c.Track(ctx, "101", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := c.Stream(ctx, "101", "", track.SampleEndMs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlist := string(get(t, c, strings.Replace(stream.StreamURL, ".128k.", ".256k.", 1)))
+	var segUrls []string
+	for _, line := range strings.Split(playlist, "\n") {
+		if strings.HasPrefix(line, "seg/") {
+			segUrls = append(segUrls, strings.TrimSuffix(stream.StreamURL, "file.128k.aac.m3u8")+line)
+		}
+	}
+	if len(segUrls) != 3 {
+		t.Fatalf("got %d segments, want 3\n%s", len(segUrls), playlist)
+	}
+	key := get(t, c, c.BaseURL+"key/101")
+	info := &adts.Info{}
+	for i, segUrl := range segUrls {
+		iv := make([]byte, 16)
+		binary.BigEndian.PutUint64(iv[8:], uint64(i))
+		data, err := decrypt.Segment(get(t, c, segUrl), key, iv)
+		if err != nil {
+			t.Fatalf("segment %d: %s", i, err)
+		}
+		segInfo, err := adts.Parse(data)
+		if err != nil {
+			t.Fatalf("segment %d: %s", i, err)
+		}
+		err = info.Add(segInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := time.Duration(track.LengthMs) * time.Millisecond
+	if info.Duration() < want || info.Duration() > want+100*time.Millisecond {
+		t.Fatalf("got %s of audio, want %s", info.Duration(), want)
+	}
+}
+
+func TestReleaseLists(t *testing.T) {
+	_, c := newClient(t)
+	ctx := context.Background()
+	page, err := c.LabelReleases(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 2 || page.Results[0].ID != 2 {
+		t.Fatalf("got %+v, want releases 2 and 1", page.Results)
+	}
+	page, err = c.ArtistReleases(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != 2 {
+		t.Fatalf("got %+v, want release 2", page.Results)
+	}
+	next, err := c.NextPage(ctx, page)
+	if next != nil || err != nil {
+		t.Fatalf("got %v, %v, want no next page", next, err)
+	}
+}
+
+func TestKeyNeedsSession(t *testing.T) {
+	_, c := newClient(t)
+	resp, err := c.Get(context.Background(), c.BaseURL+"key/101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %s, want 401", resp.Status)
+	}
+}
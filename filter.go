@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+var filterFields = []string{
+	"bpm", "key", "genre", "sub_genre", "exclusive", "is_hype",
+	"available_worldwide", "is_available_for_streaming",
+}
+
+func parseBpmRange(value string) (int, int, error) {
+	split := strings.SplitN(value, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(split[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if len(split) == 2 {
+		max, err = strconv.Atoi(strings.TrimSpace(split[1]))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if min > max {
+		return 0, 0, errors.New("Min BPM is greater than max BPM.")
+	}
+	return min, max, nil
+}
+
+func parseFilter(expr string) (*TrackFilter, error) {
+	split := strings.SplitN(expr, "=", 2)
+	if len(split) != 2 {
+		return nil, errors.New("Expected field=value: " + expr)
+	}
+	field := strings.ToLower(strings.TrimSpace(split[0]))
+	value := strings.TrimSpace(split[1])
+	if !contains(filterFields, field) {
+		return nil, errors.New("Unsupported filter field: " + field)
+	}
+	if value == "" {
+		return nil, errors.New("Filter value is empty: " + expr)
+	}
+	filter := &TrackFilter{Field: field}
+	var err error
+	switch field {
+	case "bpm":
+		filter.Min, filter.Max, err = parseBpmRange(value)
+	case "key", "genre", "sub_genre":
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v != "" {
+				filter.Values = append(filter.Values, v)
+			}
+		}
+	default:
+		filter.Bool, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for %s filter.\n%s", field, err)
+	}
+	return filter, nil
+}
+
+func parseFilters(exprs []string) ([]*TrackFilter, error) {
+	var filters []*TrackFilter
+	for _, expr := range exprs {
+		filter, err := parseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+func getCamelotKey(meta *beatport.Track) string {
+	if meta.Key.CamelotNumber == 0 {
+		return ""
+	}
+	return strconv.Itoa(meta.Key.CamelotNumber) + meta.Key.CamelotLetter
+}
+
+func getSubGenre(meta *beatport.Track) string {
+	subGenre, ok := meta.SubGenre.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := subGenre["name"].(string)
+	return name
+}
+
+func checkFilterValues(field, value string, values []string) string {
+	if contains(values, value) {
+		return ""
+	}
+	if value == "" {
+		value = "none"
+	}
+	return fmt.Sprintf("%s %s not in %s", field, value, strings.Join(values, ", "))
+}
+
+func checkFilterBool(field string, value, want bool) string {
+	if value == want {
+		return ""
+	}
+	return fmt.Sprintf("%s is %t", field, value)
+}
+
+// Returns the reason the track was filtered out, or an empty string if it passes every filter.
+func filterTrack(meta *beatport.Track, filters []*TrackFilter) string {
+	for _, filter := range filters {
+		var reason string
+		switch filter.Field {
+		case "bpm":
+			if meta.Bpm < filter.Min || meta.Bpm > filter.Max {
+				reason = fmt.Sprintf("bpm %d outside %d-%d", meta.Bpm, filter.Min, filter.Max)
+			}
+		case "key":
+			reason = checkFilterValues(filter.Field, getCamelotKey(meta), filter.Values)
+		case "genre":
+			reason = checkFilterValues(filter.Field, meta.Genre.Name, filter.Values)
+		case "sub_genre":
+			reason = checkFilterValues(filter.Field, getSubGenre(meta), filter.Values)
+		case "exclusive":
+			reason = checkFilterBool(filter.Field, meta.Exclusive, filter.Bool)
+		case "is_hype":
+			reason = checkFilterBool(filter.Field, meta.IsHype, filter.Bool)
+		case "available_worldwide":
+			reason = checkFilterBool(filter.Field, meta.AvailableWorldwide, filter.Bool)
+		case "is_available_for_streaming":
+			reason = checkFilterBool(filter.Field, meta.IsAvailableForStreaming, filter.Bool)
+		}
+		if reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func printFilterReport(filtered []*FilteredTrack) {
+	if len(filtered) == 0 {
+		return
+	}
+	fmt.Printf("\n%d track(s) filtered:\n", len(filtered))
+	for _, track := range filtered {
+		fmt.Printf("%s - %s: %s\n", track.Album, track.Title, track.Reason)
+	}
+}
@@ -1,9 +1,8 @@
-module main
+module github.com/Sorrow446/Beatport-Downloader
 
 go 1.17
 
 require (
-	github.com/Sorrow446/go-atomicparsley v0.0.0-20211012153703-f06ecab14d71
 	github.com/alexflint/go-arg v1.4.3
 	github.com/grafov/m3u8 v0.11.1
 )
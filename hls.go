@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+	"github.com/grafov/m3u8"
+)
+
+// Resolves a playlist URI, which may be relative, against the playlist's own URL.
+func resolveUrl(playlistUrl, uri string) (string, error) {
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func getKey(ctx context.Context, client *beatport.Client, keyUrl string) ([]byte, error) {
+	req, err := client.Get(ctx, keyUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+	if req.StatusCode != http.StatusOK {
+		return nil, errors.New(req.Status)
+	}
+	return ioutil.ReadAll(req.Body)
+}
+
+// Byte range segments are fetched with a Range header. Servers that ignore it get sliced instead.
+// The body's teed through w as it comes in, for progress.
+func getSegment(ctx context.Context, client *beatport.Client, segment *Segment, w io.Writer) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, segment.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if segment.Limit > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", segment.Offset, segment.Offset+segment.Limit-1))
+	}
+	do, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK && do.StatusCode != http.StatusPartialContent {
+		return nil, errors.New(do.Status)
+	}
+	segBytes, err := ioutil.ReadAll(io.TeeReader(do.Body, w))
+	if err != nil {
+		return nil, err
+	}
+	if segment.Limit > 0 && do.StatusCode == http.StatusOK {
+		end := segment.Offset + segment.Limit
+		if end > int64(len(segBytes)) {
+			return nil, errors.New("Byte range is out of bounds.")
+		}
+		segBytes = segBytes[segment.Offset:end]
+	}
+	return segBytes, nil
+}
+
+// When EXT-X-KEY has no IV, the segment's media sequence number is used as a big-endian 128-bit IV.
+func parseIv(iv string, seqNo uint64) ([]byte, error) {
+	if iv == "" {
+		ivBytes := make([]byte, 16)
+		binary.BigEndian.PutUint64(ivBytes[8:], seqNo)
+		return ivBytes, nil
+	}
+	iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+	return hex.DecodeString(iv)
+}
+
+// m3u8 reads a byte range without an offset as one at 0, so whether each segment's range
+// gave an offset is picked out of the playlist itself.
+func byteRangeOffsets(data []byte) []bool {
+	var (
+		given   []bool
+		pending bool
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			pending = strings.Contains(line, "@")
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			given = append(given, pending)
+			pending = false
+		}
+	}
+	return given
+}
+
+// Also returns whether each segment's byte range gave an offset, see byteRangeOffsets.
+func getPlaylist(ctx context.Context, client *beatport.Client, playlistUrl string) (m3u8.Playlist, m3u8.ListType, []bool, error) {
+	req, err := client.Get(ctx, playlistUrl)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer req.Body.Close()
+	if req.StatusCode != http.StatusOK {
+		return nil, 0, nil, errors.New(req.Status)
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	decoded, listType, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+	return decoded, listType, byteRangeOffsets(data), err
+}
+
+// Highest bandwidth wins. I-frame only variants are no use for audio.
+func selectVariant(master *m3u8.MasterPlaylist) (*m3u8.Variant, error) {
+	var best *m3u8.Variant
+	for _, variant := range master.Variants {
+		if variant == nil || variant.Iframe {
+			continue
+		}
+		if best == nil || variant.Bandwidth > best.Bandwidth {
+			best = variant
+		}
+	}
+	if best == nil {
+		return nil, errors.New("Master playlist has no variants.")
+	}
+	return best, nil
+}
+
+func parseMediaSegments(ctx context.Context, client *beatport.Client, media *m3u8.MediaPlaylist, offsets []bool, mediaUrl string) ([]*Segment, error) {
+	var (
+		segments []*Segment
+		key      = media.Key
+		keys     = map[string][]byte{}
+	)
+	for i, mediaSeg := range media.Segments {
+		if mediaSeg == nil {
+			break
+		}
+		// A key stays in effect until the next EXT-X-KEY.
+		if mediaSeg.Key != nil {
+			key = mediaSeg.Key
+		}
+		segUrl, err := resolveUrl(mediaUrl, mediaSeg.URI)
+		if err != nil {
+			return nil, err
+		}
+		seqNo := media.SeqNo + uint64(i)
+		segment := &Segment{
+			Url:           segUrl,
+			Offset:        mediaSeg.Offset,
+			Limit:         mediaSeg.Limit,
+			Discontinuity: mediaSeg.Discontinuity,
+		}
+		// A byte range without an offset carries on from the end of the previous one.
+		offsetGiven := i < len(offsets) && offsets[i]
+		if len(segments) > 0 && segment.Limit > 0 && !offsetGiven {
+			prev := segments[len(segments)-1]
+			if prev.Url == segment.Url && prev.Limit > 0 {
+				segment.Offset = prev.Offset + prev.Limit
+			}
+		}
+		if key != nil && key.Method != "" && key.Method != "NONE" {
+			if key.Method != "AES-128" {
+				return nil, errors.New("Unsupported encryption method: " + key.Method)
+			}
+			keyUrl, err := resolveUrl(mediaUrl, key.URI)
+			if err != nil {
+				return nil, err
+			}
+			keyBytes, ok := keys[keyUrl]
+			if !ok {
+				keyBytes, err = getKey(ctx, client, keyUrl)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to get key for segment %d.\n%s", i+1, err)
+				}
+				keys[keyUrl] = keyBytes
+			}
+			segment.Key = keyBytes
+			segment.IV, err = parseIv(key.IV, seqNo)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse IV for segment %d.\n%s", i+1, err)
+			}
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("Media playlist has no segments.")
+	}
+	return segments, nil
+}
+
+func parseSegments(ctx context.Context, client *beatport.Client, manifestUrl string) (*Playlist, error) {
+	var playlist Playlist
+	decoded, listType, offsets, err := getPlaylist(ctx, client, manifestUrl)
+	if err != nil {
+		return nil, err
+	}
+	mediaUrl := manifestUrl
+	if listType == m3u8.MASTER {
+		variant, err := selectVariant(decoded.(*m3u8.MasterPlaylist))
+		if err != nil {
+			return nil, err
+		}
+		playlist.Bandwidth = variant.Bandwidth
+		mediaUrl, err = resolveUrl(manifestUrl, variant.URI)
+		if err != nil {
+			return nil, err
+		}
+		decoded, listType, offsets, err = getPlaylist(ctx, client, mediaUrl)
+		if err != nil {
+			return nil, err
+		}
+		if listType != m3u8.MEDIA {
+			return nil, errors.New("Variant playlist isn't a media playlist.")
+		}
+	}
+	media, ok := decoded.(*m3u8.MediaPlaylist)
+	if !ok {
+		return nil, errors.New("Unexpected playlist type.")
+	}
+	playlist.Segments, err = parseMediaSegments(ctx, client, media, offsets, mediaUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
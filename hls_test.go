@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+	"github.com/grafov/m3u8"
+)
+
+func TestParseIv(t *testing.T) {
+	tests := []struct {
+		name    string
+		iv      string
+		seqNo   uint64
+		want    string
+		wantErr bool
+	}{
+		{"implicit", "", 5, "00000000000000000000000000000005", false},
+		{"implicit large", "", 1<<32 + 1, "00000000000000000000000100000001", false},
+		{"explicit", "0x0102030405060708090a0b0c0d0e0f10", 5, "0102030405060708090a0b0c0d0e0f10", false},
+		{"upper case prefix", "0X0102030405060708090A0B0C0D0E0F10", 5, "0102030405060708090a0b0c0d0e0f10", false},
+		{"not hex", "0xzz", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iv, err := parseIv(tt.iv, tt.seqNo)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got IV %x, want an error", iv)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(iv); got != tt.want {
+				t.Fatalf("got IV %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Serves playlists by path, and keys whose bytes are their path, counting how often each key's fetched.
+func newHlsServer(t *testing.T, playlists map[string]string) (*httptest.Server, map[string]int) {
+	t.Helper()
+	var mu sync.Mutex
+	keyHits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/keys/") {
+			mu.Lock()
+			keyHits[r.URL.Path]++
+			mu.Unlock()
+			w.Write([]byte(r.URL.Path))
+			return
+		}
+		playlist, ok := playlists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(playlist))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, keyHits
+}
+
+func decodeMedia(t *testing.T, playlist string) (*m3u8.MediaPlaylist, []bool) {
+	t.Helper()
+	decoded, listType, err := m3u8.DecodeFrom(strings.NewReader(playlist), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != m3u8.MEDIA {
+		t.Fatal("not a media playlist")
+	}
+	return decoded.(*m3u8.MediaPlaylist), byteRangeOffsets([]byte(playlist))
+}
+
+func TestParseMediaSegments(t *testing.T) {
+	type wantSeg struct {
+		url           string
+		key           string
+		iv            string
+		offset        int64
+		limit         int64
+		discontinuity bool
+	}
+	tests := []struct {
+		name     string
+		playlist string
+		want     []wantSeg
+		keyHits  map[string]int
+		wantErr  string
+	}{
+		{
+			name: "unencrypted",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+seg/0.aac
+#EXTINF:4,
+https://cdn.example.com/seg/1.aac
+#EXT-X-ENDLIST
+`,
+			want: []wantSeg{
+				{url: "/media/seg/0.aac"},
+				{url: "https://cdn.example.com/seg/1.aac"},
+			},
+		},
+		{
+			name: "key rotation and caching",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/a"
+#EXTINF:4,
+seg/0.aac
+#EXTINF:4,
+seg/1.aac
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/b",IV=0x000000000000000000000000000000ff
+#EXTINF:4,
+seg/2.aac
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/a"
+#EXTINF:4,
+seg/3.aac
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:4,
+seg/4.aac
+#EXT-X-ENDLIST
+`,
+			want: []wantSeg{
+				{url: "/media/seg/0.aac", key: "/keys/a", iv: "0000000000000000000000000000000a"},
+				{url: "/media/seg/1.aac", key: "/keys/a", iv: "0000000000000000000000000000000b"},
+				{url: "/media/seg/2.aac", key: "/keys/b", iv: "000000000000000000000000000000ff"},
+				{url: "/media/seg/3.aac", key: "/keys/a", iv: "0000000000000000000000000000000d"},
+				{url: "/media/seg/4.aac"},
+			},
+			keyHits: map[string]int{"/keys/a": 1, "/keys/b": 1},
+		},
+		{
+			name: "byte ranges and discontinuities",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+#EXT-X-BYTERANGE:100@0
+all.aac
+#EXTINF:4,
+#EXT-X-BYTERANGE:150
+all.aac
+#EXT-X-DISCONTINUITY
+#EXTINF:4,
+#EXT-X-BYTERANGE:50@1000
+all.aac
+#EXTINF:4,
+#EXT-X-BYTERANGE:60
+all.aac
+#EXT-X-ENDLIST
+`,
+			want: []wantSeg{
+				{url: "/media/all.aac", offset: 0, limit: 100},
+				{url: "/media/all.aac", offset: 100, limit: 150},
+				{url: "/media/all.aac", offset: 1000, limit: 50, discontinuity: true},
+				{url: "/media/all.aac", offset: 1050, limit: 60},
+			},
+		},
+		{
+			name: "byte range back at the start",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+#EXT-X-BYTERANGE:100@0
+all.aac
+#EXTINF:4,
+#EXT-X-BYTERANGE:100@0
+all.aac
+#EXT-X-ENDLIST
+`,
+			want: []wantSeg{
+				{url: "/media/all.aac", offset: 0, limit: 100},
+				{url: "/media/all.aac", offset: 0, limit: 100},
+			},
+		},
+		{
+			name: "unsupported method",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="/keys/a"
+#EXTINF:4,
+seg/0.aac
+#EXT-X-ENDLIST
+`,
+			wantErr: "Unsupported encryption method: SAMPLE-AES",
+		},
+		{
+			name: "missing key",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-KEY:METHOD=AES-128,URI="/nokey"
+#EXTINF:4,
+seg/0.aac
+#EXT-X-ENDLIST
+`,
+			wantErr: "Failed to get key for segment 1.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, keyHits := newHlsServer(t, nil)
+			mediaUrl := srv.URL + "/media/index.m3u8"
+			media, offsets := decodeMedia(t, tt.playlist)
+			segments, err := parseMediaSegments(context.Background(), beatport.NewClient(), media, offsets, mediaUrl)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(segments) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := segments[i]
+				wantUrl := want.url
+				if strings.HasPrefix(wantUrl, "/") {
+					wantUrl = srv.URL + wantUrl
+				}
+				if got.Url != wantUrl || got.Offset != want.offset || got.Limit != want.limit || got.Discontinuity != want.discontinuity {
+					t.Errorf("segment %d: got %s %d@%d discontinuity %t, want %s %d@%d discontinuity %t", i,
+						got.Url, got.Limit, got.Offset, got.Discontinuity, wantUrl, want.limit, want.offset, want.discontinuity)
+				}
+				if !bytes.Equal(got.Key, []byte(want.key)) && !(got.Key == nil && want.key == "") {
+					t.Errorf("segment %d: got key %q, want %q", i, got.Key, want.key)
+				}
+				if gotIv := hex.EncodeToString(got.IV); gotIv != want.iv {
+					t.Errorf("segment %d: got IV %s, want %s", i, gotIv, want.iv)
+				}
+			}
+			for key, hits := range tt.keyHits {
+				if keyHits[key] != hits {
+					t.Errorf("%s fetched %d time(s), want %d", key, keyHits[key], hits)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSegmentsMaster(t *testing.T) {
+	media := `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+seg/0.aac
+#EXT-X-ENDLIST
+`
+	tests := []struct {
+		name      string
+		master    string
+		wantUrl   string
+		bandwidth uint32
+		wantErr   bool
+	}{
+		{
+			name: "highest bandwidth",
+			master: `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS="mp4a.40.2"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=256000,CODECS="mp4a.40.2"
+high/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=900000,URI="iframe/index.m3u8"
+`,
+			wantUrl:   "/high/seg/0.aac",
+			bandwidth: 256000,
+		},
+		{
+			name: "only i-frames",
+			master: `#EXTM3U
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=900000,URI="iframe/index.m3u8"
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newHlsServer(t, map[string]string{
+				"/master.m3u8":       tt.master,
+				"/low/index.m3u8":    media,
+				"/high/index.m3u8":   media,
+				"/iframe/index.m3u8": media,
+			})
+			playlist, err := parseSegments(context.Background(), beatport.NewClient(), srv.URL+"/master.m3u8")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if playlist.Bandwidth != tt.bandwidth {
+				t.Errorf("got bandwidth %d, want %d", playlist.Bandwidth, tt.bandwidth)
+			}
+			if len(playlist.Segments) != 1 || playlist.Segments[0].Url != srv.URL+tt.wantUrl {
+				t.Errorf("got segments %+v, want one at %s", playlist.Segments, tt.wantUrl)
+			}
+		})
+	}
+}
+
+func TestProbeStream(t *testing.T) {
+	media := `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXTINF:4,
+seg/0.aac
+#EXT-X-ENDLIST
+`
+	master := `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=256000,CODECS="mp4a.40.2"
+media.m3u8
+`
+	tests := []struct {
+		name        string
+		streamPath  string
+		quality     int
+		wantBitrate int
+	}{
+		{"preferred quality", "/track.128k.aac.m3u8", 256, 256},
+		{"falls back", "/missing.128k.aac.m3u8", 256, 128},
+		{"unknown URL, master playlist", "/master.m3u8", 256, 256},
+		// No bandwidth to go by, so it's unknown rather than 0 kbps.
+		{"unknown URL, media playlist", "/media.m3u8", 256, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newHlsServer(t, map[string]string{
+				"/track.128k.aac.m3u8":   media,
+				"/track.256k.aac.m3u8":   media,
+				"/missing.128k.aac.m3u8": media,
+				"/master.m3u8":           master,
+				"/media.m3u8":            media,
+			})
+			jobs, _ := newJobStore("")
+			job := jobs.add(srv.URL+tt.streamPath, "")
+			playlist, err := probeStream(context.Background(), beatport.NewClient(), srv.URL+tt.streamPath, tt.quality, job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if playlist.Bitrate != tt.wantBitrate {
+				t.Errorf("got bitrate %d, want %d", playlist.Bitrate, tt.wantBitrate)
+			}
+		})
+	}
+}
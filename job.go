@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+
+	trackPending     = "pending"
+	trackDownloading = "downloading"
+	trackMuxing      = "muxing"
+	trackTagged      = "tagged"
+	trackSkipped     = "skipped"
+	trackFiltered    = "filtered"
+	trackFailed      = "failed"
+)
+
+const (
+	// How long log lines wait to be saved, so a chatty track doesn't rewrite the file for every one.
+	saveDelay = time.Second
+	// Older lines are dropped so jobs that run for ages don't keep growing.
+	jobLogMax = 1000
+)
+
+var (
+	errJobCancelled = errors.New("Job cancelled.")
+	errLocked       = errors.New("Locked by another process.")
+)
+
+func writeJsonAtomic(path string, obj interface{}) error {
+	data, err := json.MarshalIndent(obj, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// An empty path keeps the store in memory only, which is what watch uses for each poll.
+// Otherwise the file's locked until close, as each process saves its whole copy over it.
+func newJobStore(path string) (*JobStore, error) {
+	store := &JobStore{path: path}
+	if path == "" {
+		return store, nil
+	}
+	lock, err := lockFile(path + ".lock")
+	if err == errLocked {
+		return nil, fmt.Errorf("%s is in use by another run. Wait for it to finish, or give this one its own queue path.", path)
+	} else if err != nil {
+		return nil, err
+	}
+	store.lock = lock
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	err = json.Unmarshal(data, store)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	for _, job := range store.Jobs {
+		job.store = store
+		if job.ID >= store.NextID {
+			store.NextID = job.ID + 1
+		}
+		// Whatever was running when we went down gets picked up again.
+		if job.Status == jobRunning {
+			job.Status = jobQueued
+			if job.Cancelled {
+				job.Status = jobCancelled
+			}
+		}
+	}
+	return store, nil
+}
+
+// Caller must hold the lock.
+func (s *JobStore) save() {
+	s.dirty = false
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	if s.path == "" {
+		return
+	}
+	err := writeJsonAtomic(s.path, s)
+	if err != nil {
+		handleErr("Failed to save jobs.", err)
+	}
+}
+
+// Caller must hold the lock.
+func (s *JobStore) saveLater() {
+	s.dirty = true
+	if s.path == "" || s.saveTimer != nil {
+		return
+	}
+	s.saveTimer = time.AfterFunc(saveDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.saveTimer = nil
+		if s.dirty {
+			s.save()
+		}
+	})
+}
+
+// Saves anything that's waiting and lets other processes have the file.
+func (s *JobStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty {
+		s.save()
+	}
+	if s.lock != nil {
+		s.lock.Close()
+		s.lock = nil
+	}
+}
+
+func (s *JobStore) add(_url, profile string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.NextID == 0 {
+		s.NextID = 1
+	}
+	now := time.Now()
+	job := &Job{
+		ID:      s.NextID,
+		Url:     _url,
+		Profile: profile,
+		Status:  jobQueued,
+		Created: now,
+		Updated: now,
+		store:   s,
+	}
+	s.NextID++
+	s.Jobs = append(s.Jobs, job)
+	s.save()
+	return job
+}
+
+func (s *JobStore) get(id int) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.Jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Returns profile's unfinished job for _url, if any.
+func (s *JobStore) find(_url, profile string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.Jobs {
+		if job.Url == _url && job.Profile == profile && (job.Status == jobQueued || job.Status == jobRunning) {
+			return job
+		}
+	}
+	return nil
+}
+
+// Other profiles' albums are left for when they're next used.
+func (s *JobStore) unfinished(profile string) []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []*Job
+	for _, job := range s.Jobs {
+		if job.Profile == profile && (job.Status == jobQueued || job.Status == jobRunning) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+func (s *JobStore) remove(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.Jobs {
+		if j == job {
+			s.Jobs = append(s.Jobs[:i], s.Jobs[i+1:]...)
+			break
+		}
+	}
+	s.save()
+}
+
+// Drops all but the newest keep finished jobs.
+func (s *JobStore) prune(keep int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var (
+		jobs     []*Job
+		finished int
+	)
+	for i := len(s.Jobs) - 1; i >= 0; i-- {
+		job := s.Jobs[i]
+		switch job.Status {
+		case jobDone, jobFailed, jobCancelled:
+			finished++
+			if finished > keep {
+				continue
+			}
+		}
+		jobs = append([]*Job{job}, jobs...)
+	}
+	if len(jobs) == len(s.Jobs) {
+		return
+	}
+	s.Jobs = jobs
+	s.save()
+}
+
+func (s *JobStore) next() *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.Jobs {
+		if job.Status == jobQueued {
+			return job
+		}
+	}
+	return nil
+}
+
+// obj is called with the lock held so it can safely hand back jobs for marshalling.
+func (s *JobStore) marshal(obj func() interface{}) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(obj())
+}
+
+// State changes are saved straight away, so a crash can be resumed from them.
+func (j *Job) update(f func()) {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	f()
+	j.Updated = time.Now()
+	j.store.save()
+}
+
+// For log lines and titles, which can wait for the next save.
+func (j *Job) updateLater(f func()) {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	f()
+	j.Updated = time.Now()
+	j.store.saveLater()
+}
+
+func (j *Job) log(a ...interface{}) {
+	line := fmt.Sprintln(a...)
+	progress.clear()
+	fmt.Print(line)
+	j.updateLater(func() {
+		j.appendLog(line[:len(line)-1])
+	})
+}
+
+func (j *Job) logf(format string, a ...interface{}) {
+	line := fmt.Sprintf(format, a...)
+	progress.clear()
+	fmt.Print(line)
+	j.updateLater(func() {
+		j.appendLog(strings.TrimSuffix(line, "\n"))
+	})
+}
+
+// Caller must hold the lock.
+func (j *Job) appendLog(line string) {
+	j.Log = append(j.Log, line)
+	if len(j.Log) > jobLogMax {
+		j.Log = append([]string(nil), j.Log[len(j.Log)-jobLogMax:]...)
+	}
+}
+
+func (j *Job) handleErr(errText string, err error) {
+	j.log(errText + "\n" + err.Error())
+}
+
+func (j *Job) trackFailed(trackNum int, errText string, err error) {
+	j.handleErr(errText, err)
+	j.setTrackStatus(trackNum, trackFailed, err.Error())
+	j.emit(&Event{Event: eventError, Context: errText, Error: err.Error()}, trackNum)
+}
+
+func (j *Job) setStatus(status string, err error) {
+	j.update(func() {
+		j.Status = status
+		if err != nil {
+			j.Error = err.Error()
+		}
+	})
+}
+
+// Tracks from an interrupted run of the same job are kept so their states can be resumed from.
+func (j *Job) setAlbum(album, cover string, trackTotal int) {
+	j.update(func() {
+		j.Album = album
+		j.Cover = cover
+		if len(j.Tracks) == trackTotal {
+			return
+		}
+		j.Tracks = make([]*JobTrack, trackTotal)
+		for i := range j.Tracks {
+			j.Tracks[i] = &JobTrack{Num: i + 1, Status: trackPending}
+		}
+	})
+}
+
+func (j *Job) setTrack(trackNum int, trackId, title string) {
+	j.updateLater(func() {
+		track := j.Tracks[trackNum-1]
+		track.ID = trackId
+		track.Title = title
+	})
+}
+
+func (j *Job) setTrackStatus(trackNum int, status, reason string) {
+	j.update(func() {
+		track := j.Tracks[trackNum-1]
+		track.Status = status
+		track.Error = reason
+	})
+	progress.setTracks(j.trackCounts())
+}
+
+func (j *Job) setTrackPath(trackNum int, path string) {
+	j.updateLater(func() {
+		j.Tracks[trackNum-1].Path = path
+	})
+}
+
+// The track's state as of the last run of the job, or a pending one for a new job.
+func (j *Job) savedTrack(trackNum int) JobTrack {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	return *j.Tracks[trackNum-1]
+}
+
+func (j *Job) trackLabel(trackNum int) string {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	return fmt.Sprintf("%02d. %s", trackNum, j.Tracks[trackNum-1].Title)
+}
+
+// Only kept in memory until something else is saved. Resume goes by the work dir's manifest.
+func (j *Job) setSegment(trackNum, segNum, segTotal int) {
+	j.store.mu.Lock()
+	track := j.Tracks[trackNum-1]
+	track.Segment = segNum
+	track.SegmentTotal = segTotal
+	j.Updated = time.Now()
+	j.store.mu.Unlock()
+	j.emit(&Event{Event: eventSegmentProgress, Segment: segNum, SegmentTotal: segTotal}, trackNum)
+}
+
+// Only set while the job's running.
+func (j *Job) setCancelFunc(cancel context.CancelFunc) {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	j.cancelFunc = cancel
+}
+
+func (j *Job) cancel() {
+	j.update(func() {
+		j.Cancelled = true
+		if j.Status == jobQueued {
+			j.Status = jobCancelled
+		}
+		if j.cancelFunc != nil {
+			j.cancelFunc()
+		}
+	})
+}
+
+// Only finished jobs can be retried.
+func (j *Job) retry() bool {
+	var ok bool
+	j.update(func() {
+		if j.Status != jobFailed && j.Status != jobCancelled {
+			return
+		}
+		j.Status = jobQueued
+		j.Error = ""
+		j.Cancelled = false
+		ok = true
+	})
+	return ok
+}
+
+func (j *Job) isCancelled() bool {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	return j.Cancelled
+}
+
+// Tracks that are finished with, whether downloaded, skipped or filtered, out of the total.
+func (j *Job) trackCounts() (int, int) {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	var done int
+	for _, track := range j.Tracks {
+		switch track.Status {
+		case trackTagged, trackSkipped, trackFiltered:
+			done++
+		}
+	}
+	return done, len(j.Tracks)
+}
+
+func (j *Job) trackErr() error {
+	j.store.mu.Lock()
+	defer j.store.mu.Unlock()
+	var failed int
+	for _, track := range j.Tracks {
+		if track.Status == trackFailed {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d track(s) failed.", failed)
+}
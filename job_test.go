@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	jobs, err := newJobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newJobStore(path)
+	if err == nil || !strings.Contains(err.Error(), "in use by another run") {
+		t.Fatalf("got error %v opening a store that's in use", err)
+	}
+	job := jobs.add("https://www.beatport.com/release/kindred/1", "")
+	job.setAlbum("Kindred", "", 2)
+	job.setTrackStatus(1, trackTagged, "")
+	job.setSegment(2, 3, 10)
+	// Saved by close, not straight away.
+	job.log("Downloading track 2 of 2")
+	jobs.close()
+
+	jobs, err = newJobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobs.close()
+	job = jobs.get(1)
+	if job == nil {
+		t.Fatal("job wasn't saved")
+	}
+	if job.Tracks[0].Status != trackTagged || job.Tracks[1].Segment != 3 {
+		t.Errorf("got tracks %+v, %+v", job.Tracks[0], job.Tracks[1])
+	}
+	if len(job.Log) != 1 {
+		t.Errorf("got log %q", job.Log)
+	}
+}
+
+func TestJobStorePrune(t *testing.T) {
+	jobs, _ := newJobStore("")
+	statuses := []string{jobDone, jobQueued, jobFailed, jobCancelled, jobRunning, jobDone}
+	for _, status := range statuses {
+		job := jobs.add("https://www.beatport.com/release/kindred/1", "")
+		job.setStatus(status, nil)
+	}
+	jobs.prune(2)
+	var ids []int
+	for _, job := range jobs.Jobs {
+		ids = append(ids, job.ID)
+	}
+	// The two oldest finished jobs go, unfinished ones stay whatever their age.
+	if fmt.Sprint(ids) != "[2 4 5 6]" {
+		t.Errorf("got jobs %v, want [2 4 5 6]", ids)
+	}
+	job := jobs.get(2)
+	for i := 0; i < jobLogMax+10; i++ {
+		job.log(i)
+	}
+	if len(job.Log) != jobLogMax || job.Log[0] != "10" {
+		t.Errorf("got %d log line(s) starting with %q", len(job.Log), job.Log[0])
+	}
+}
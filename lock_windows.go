@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// Opened without sharing, so no one else can open it until we exit.
+func lockFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(
+		name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0,
+	)
+	if err == errorSharingViolation {
+		return nil, errLocked
+	} else if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -3,9 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,55 +11,87 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+	"github.com/Sorrow446/Beatport-Downloader/decrypt"
 
-	ap "github.com/Sorrow446/go-atomicparsley"
 	"github.com/alexflint/go-arg"
-	"github.com/grafov/m3u8"
 )
 
 const (
-	regexString = `^https://www.beatport.com/release/[a-z0-9-]+/(\d+)$`
-	userAgent   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit" +
-		"/537.36 (KHTML, like Gecko) Chrome/99.0.4844.82 Safari/537.36"
-	baseUrl       = "https://www.beatport.com/"
-	apiBase       = baseUrl + "api/v4/"
+	regexString   = `^release/[a-z0-9-]+/(\d+)$`
 	trackTemplate = "{{.trackPad}}. {{.title}}"
 	albumTemplate = "{{.albumArtist}} - {{.album}}"
 )
 
 var (
-	jar, _ = cookiejar.New(nil)
-	client = &http.Client{Transport: &Transport{}, Jar: jar}
+	// Set by --record, --replay and --trace. Shared by every client, so the daemon's sessions and covers
+	// go in one cassette and trace.
+	transport http.RoundTripper
+	// Release, label and artist URLs are accepted from beatport.com, and from baseUrl if it's set.
+	siteUrls = []string{beatport.DefaultBaseURL}
 )
 
-func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Add(
-		"User-Agent", userAgent,
-	)
-	return http.DefaultTransport.RoundTrip(req)
+// Each profile gets its own client, and so its own cookies.
+func newClient(cfg *Config) *beatport.Client {
+	c := beatport.NewClient()
+	if cfg.BaseUrl != "" {
+		c.BaseURL = cfg.BaseUrl
+	}
+	c.APIURL = cfg.ApiUrl
+	if transport != nil {
+		c.HTTPClient.Transport = transport
+	}
+	return c
+}
+
+func setupTransport(cfg *Config) error {
+	switch {
+	case cfg.RecordPath != "" && cfg.ReplayPath != "":
+		return errors.New("--record and --replay can't be used together.")
+	case cfg.RecordPath != "":
+		// Scrubbed, but still a record of what the account did.
+		f, err := os.OpenFile(cfg.RecordPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		transport = beatport.NewRecorder(f, nil)
+	case cfg.ReplayPath != "":
+		replayer, err := beatport.LoadCassette(cfg.ReplayPath)
+		if err != nil {
+			return err
+		}
+		transport = replayer
+	}
+	if cfg.TracePath != "" {
+		f, err := os.OpenFile(cfg.TracePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		transport = beatport.NewTracer(f, transport)
+	}
+	return nil
 }
 
 func getTempPath() (string, error) {
 	return os.MkdirTemp(os.TempDir(), "")
 }
 
-func handleErr(errText string, err error, _panic bool) {
-	errString := errText + "\n" + err.Error()
-	if _panic {
-		panic(errString)
-	}
-	fmt.Println(errString)
+// Non-fatal errors only. Fatal ones are returned up to main so it can pick the exit code.
+func handleErr(errText string, err error) {
+	fmt.Println(errText + "\n" + err.Error())
 }
 
 func wasRunFromSrc() bool {
@@ -145,43 +175,80 @@ func processUrls(urls []string) ([]string, error) {
 	return processed, nil
 }
 
-func readConfig() (*Config, error) {
-	data, err := ioutil.ReadFile("config.json")
+func readConfig(cfgPath, profile string) (*Config, error) {
+	cfgPath, err := findConfig(cfgPath)
 	if err != nil {
 		return nil, err
 	}
 	var obj Config
-	err = json.Unmarshal(data, &obj)
+	if cfgPath != "" {
+		data, err := ioutil.ReadFile(cfgPath)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &obj)
+		if err != nil {
+			return nil, err
+		}
+		obj.Path, err = filepath.Abs(cfgPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Picked before env vars are applied, so they can still override the profile's settings.
+	if profile == "" {
+		profile = os.Getenv(envPrefix + "PROFILE")
+	}
+	if profile == "" {
+		profile = obj.Profile
+	}
+	if profile != "" {
+		err = applyProfile(&obj, profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = applyEnv(reflect.ValueOf(&obj).Elem(), envPrefix)
 	if err != nil {
 		return nil, err
 	}
+	obj.Profile = profile
 	return &obj, nil
 }
 
-func parseArgs() *Args {
-	var args Args
-	arg.MustParse(&args)
-	return &args
-}
-
-func parseCfg() (*Config, error) {
-	cfg, err := readConfig()
-	if err != nil {
-		return nil, err
-	}
-	args := parseArgs()
-	if args.OutPath != "" {
-		cfg.OutPath = args.OutPath
+// Bad args are config errors so they exit with 2. Help's printed and returned as arg.ErrHelp, which exits with 0.
+func parseArgsInto(name string, flags []string, dest interface{}) error {
+	config := arg.Config{Program: filepath.Base(os.Args[0])}
+	if name != "" {
+		config.Program += " " + name
 	}
-	if args.MaxCover {
-		cfg.MaxCover = args.MaxCover
-	}
-	if args.AlbumTemplate != "" {
-		cfg.AlbumTemplate = args.AlbumTemplate
+	p, err := arg.NewParser(config, dest)
+	if err != nil {
+		panic(err)
 	}
-	if args.TrackTemplate != "" {
-		cfg.TrackTemplate = args.TrackTemplate
+	err = p.Parse(flags)
+	if err == arg.ErrHelp {
+		p.WriteHelp(os.Stdout)
+		return err
+	} else if err != nil {
+		p.WriteUsage(os.Stderr)
+		return configErr("Bad arguments.", err)
 	}
+	return nil
+}
+
+func parseArgs() (*Args, error) {
+	var args Args
+	err := parseArgsInto("", os.Args[1:], &args)
+	return &args, err
+}
+
+// Parses the args following a subcommand name, e.g. "watch".
+func parseSubArgs(name string, dest interface{}) error {
+	return parseArgsInto(name, os.Args[2:], dest)
+}
+
+func setCfgDefaults(cfg *Config) error {
 	if cfg.AlbumTemplate == "" {
 		cfg.AlbumTemplate = albumTemplate
 	}
@@ -191,131 +258,102 @@ func parseCfg() (*Config, error) {
 	if cfg.OutPath == "" {
 		cfg.OutPath = "Beatport downloads"
 	}
-	cfg.Urls, err = processUrls(args.Urls)
-	if err != nil {
-		errString := fmt.Sprintf("Failed to process URLs.\n%s", err)
-		return nil, errors.New(errString)
-	}
-	return cfg, nil
-}
-
-func makeDirs(path string) error {
-	return os.MkdirAll(path, 0755)
-}
-
-func checkUrl(url string) string {
-	regex := regexp.MustCompile(regexString)
-	match := regex.FindStringSubmatch(url)
-	if match == nil {
-		return ""
-	}
-	return match[1]
-}
-
-func getCsrfToken() (string, error) {
-	_url := baseUrl + "account/login"
-	req, err := http.NewRequest(http.MethodGet, _url, nil)
-	if err != nil {
-		return "", err
+	if cfg.QueuePath == "" {
+		cfg.QueuePath = "queue.json"
 	}
-	req.Header.Add("Referer", _url)
-	do, err := client.Do(req)
-	if err != nil {
-		return "", err
+	if cfg.WorkPath == "" {
+		cfg.WorkPath = "work"
 	}
-	do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return "", errors.New(do.Status)
+	if cfg.Watch.Interval < 1 {
+		cfg.Watch.Interval = watchInterval
 	}
-	var csrf string
-	for _, c := range do.Cookies() {
-		if c.Name == "_csrf_token" {
-			csrf = c.Value
-			break
-		}
+	if cfg.Watch.StatePath == "" {
+		cfg.Watch.StatePath = watchStatePath
 	}
-	if csrf == "" {
-		return "", errors.New("Csrf token wasn't returned by server.")
+	if cfg.Daemon.Listen == "" {
+		cfg.Daemon.Listen = daemonListen
 	}
-	return csrf, nil
-}
-
-func auth(email, pwd string) error {
-	csrfToken, err := getCsrfToken()
-	if err != nil {
-		return errors.New("Failed to get CSRF token.\n" + err.Error())
+	if cfg.Daemon.JobsPath == "" {
+		cfg.Daemon.JobsPath = daemonJobsPath
 	}
-	_url := baseUrl + "account/login"
-	data := url.Values{}
-	data.Set("_csrf_token", csrfToken)
-	data.Set("next", "")
-	data.Set("username", email)
-	data.Set("password", pwd)
-	req, err := http.NewRequest(http.MethodPost, _url, strings.NewReader(data.Encode()))
+	stateDir, err := getStateDir()
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Referer", _url)
-	do, err := client.Do(req)
-	if err != nil {
-		return err
+	for _, path := range []*string{&cfg.QueuePath, &cfg.WorkPath, &cfg.Watch.StatePath, &cfg.Daemon.JobsPath} {
+		*path = resolveStatePath(stateDir, *path)
 	}
-	do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return errors.New(do.Status)
+	if cfg.Quality == 0 {
+		cfg.Quality = defQuality
 	}
-	if do.Request.URL.String() == _url {
-		return errors.New("Redirected to login page. Bad credentials?")
+	// The client joins paths straight on to these.
+	for _, _url := range []*string{&cfg.BaseUrl, &cfg.ApiUrl} {
+		if *_url != "" && !strings.HasSuffix(*_url, "/") {
+			*_url += "/"
+		}
 	}
-	return nil
-}
-
-func getPlan() (string, error) {
-	req, err := http.NewRequest(http.MethodGet, apiBase+"my/subscriptions", nil)
+	err = checkQuality(cfg.Quality)
 	if err != nil {
-		return "", err
+		return err
 	}
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Referer", baseUrl+"subscriptions")
-	do, err := client.Do(req)
+	// Segment paths end up in FFmpeg's concat list, which resolves relative paths from the list's own folder.
+	cfg.WorkPath, err = filepath.Abs(cfg.WorkPath)
 	if err != nil {
-		return "", err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return "", errors.New(do.Status)
+		return err
 	}
-	var obj UserSub
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	cfg.TrackFilters, err = parseFilters(cfg.Filters)
 	if err != nil {
-		return "", err
+		errString := fmt.Sprintf("Failed to parse filters.\n%s", err)
+		return errors.New(errString)
 	}
-	return obj.Subscription.Bundle.Name, nil
+	return nil
 }
 
-func getAlbumMeta(albumId, ref string) (*AlbumMeta, error) {
-	req, err := http.NewRequest(http.MethodGet, apiBase+"catalog/releases/"+albumId, nil)
+func parseCfg() (*Config, error) {
+	args, err := parseArgs()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Referer", ref)
-	do, err := client.Do(req)
+	cfg, err := readConfig(args.Config, args.Profile)
 	if err != nil {
 		return nil, err
 	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return nil, errors.New(do.Status)
-	}
-	var obj AlbumMeta
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	applyArgs(cfg, args)
+	err = setCfgDefaults(cfg)
 	if err != nil {
 		return nil, err
 	}
-	sort.Strings(obj.Tracks)
-	return &obj, nil
+	cfg.Urls, err = processUrls(args.Urls)
+	if err != nil {
+		errString := fmt.Sprintf("Failed to process URLs.\n%s", err)
+		return nil, errors.New(errString)
+	}
+	return cfg, nil
+}
+
+func makeDirs(path string) error {
+	return os.MkdirAll(path, 0755)
+}
+
+func matchSiteUrl(_url, pathRegex string) []string {
+	regex := regexp.MustCompile(pathRegex)
+	for _, siteUrl := range siteUrls {
+		if strings.HasPrefix(_url, siteUrl) {
+			match := regex.FindStringSubmatch(_url[len(siteUrl):])
+			if match != nil {
+				return match
+			}
+		}
+	}
+	return nil
+}
+
+func checkUrl(url string) string {
+	match := matchSiteUrl(url, regexString)
+	if match == nil {
+		return ""
+	}
+	return match[1]
 }
 
 func sanitize(filename string) string {
@@ -324,38 +362,43 @@ func sanitize(filename string) string {
 	return sanitized
 }
 
-func parseArtists(artists []Artist) string {
-	var parsedArtists string
+func parseArtists(artists []beatport.Artist) string {
+	var names []string
 	for _, artist := range artists {
-		parsedArtists += artist.Name + ", "
+		names = append(names, artist.Name)
 	}
-	return parsedArtists[:len(parsedArtists)-2]
+	return strings.Join(names, ", ")
 }
 
-func parseAlbumMeta(meta *AlbumMeta) map[string]string {
+func parseAlbumMeta(meta *beatport.Release) map[string]string {
+	// Metadata's sometimes missing, so nothing's taken for granted.
+	var year string
+	if len(meta.PublishDate) >= 4 {
+		year = meta.PublishDate[:4]
+	}
 	parsedMeta := map[string]string{
 		"album":         meta.Name,
 		"albumArtist":   parseArtists(meta.Artists),
 		"catalogNumber": meta.CatalogNumber,
-		"year":          meta.PublishDate[:4],
+		"year":          year,
 	}
-	upc := meta.Upc
-	if upc != nil {
-		parsedMeta["upc"] = upc.(string)
+	upc, ok := meta.Upc.(string)
+	if ok {
+		parsedMeta["upc"] = upc
 	}
 	return parsedMeta
 }
 
-func parseTrackMeta(meta *TrackMeta, albMeta map[string]string, trackNum, trackTotal int, omit bool) (map[string]string, string) {
+func parseTrackMeta(meta *beatport.Track, albMeta map[string]string, trackNum, trackTotal int, omit bool) (map[string]string, string) {
 	albMeta["artist"] = parseArtists(meta.Artists)
 	albMeta["bpm"] = strconv.Itoa(meta.Bpm)
 	albMeta["genre"] = meta.Genre.Name
 	albMeta["track"] = strconv.Itoa(trackNum)
 	albMeta["trackPad"] = fmt.Sprintf("%02d", trackNum)
 	albMeta["trackTotal"] = strconv.Itoa(trackTotal)
-	isrc := meta.Isrc
-	if isrc != nil {
-		albMeta["isrc"] = isrc.(string)
+	isrc, ok := meta.Isrc.(string)
+	if ok {
+		albMeta["isrc"] = isrc
 	}
 	mixName := meta.MixName
 	titleWithMixName := meta.Name + " (" + mixName + ")"
@@ -380,29 +423,6 @@ func getTrackId(trackUrl string) (string, error) {
 	return path.Base(u.Path), nil
 }
 
-func getTrackMeta(trackId, ref string) (*TrackMeta, error) {
-	req, err := http.NewRequest(http.MethodGet, apiBase+"catalog/tracks/"+trackId, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Referer", ref)
-	do, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return nil, errors.New(do.Status)
-	}
-	var obj TrackMeta
-	err = json.NewDecoder(do.Body).Decode(&obj)
-	if err != nil {
-		return nil, err
-	}
-	return &obj, nil
-}
-
 func fileExists(path string) (bool, error) {
 	f, err := os.Stat(path)
 	if err == nil {
@@ -413,157 +433,72 @@ func fileExists(path string) (bool, error) {
 	return false, err
 }
 
-func getTrackStreamUrl(trackId, ref string, sampleEnd int) (string, error) {
-	req, err := http.NewRequest(http.MethodGet, apiBase+"catalog/tracks/"+trackId+"/stream", nil)
-	if err != nil {
-		return "", err
-	}
-	query := url.Values{}
-	query.Set("start", "0")
-	query.Set("end", strconv.Itoa(sampleEnd))
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Referer", ref)
-	req.URL.RawQuery = query.Encode()
-	do, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return "", errors.New(do.Status)
-	}
-	var obj TrackStream
-	err = json.NewDecoder(do.Body).Decode(&obj)
-	if err != nil {
-		return "", err
-	}
-	streamUrl := strings.Replace(obj.StreamURL, ".128k.", ".256k.", 1)
-	return streamUrl, nil
-}
-
-func getBaseUrl(manifestUrl string) (string, error) {
-	u, err := url.Parse(manifestUrl)
-	if err != nil {
-		return "", err
-	}
-	return u.Scheme + "://" + u.Host + path.Dir(u.Path) + "/", nil
-}
-
-func getKey(keyUrl string) ([]byte, error) {
-	req, err := client.Get(keyUrl)
-	if err != nil {
-		return nil, err
-	}
-	defer req.Body.Close()
-	if req.StatusCode != http.StatusOK {
-		return nil, errors.New(req.Status)
-	}
-	return ioutil.ReadAll(req.Body)
-}
-
-func parseKeyIv(segments *Segments, keyUrl, iv string) error {
-	keyBytes, err := getKey(keyUrl)
+func writeSegment(segPath string, segBytes []byte) error {
+	f, err := os.OpenFile(segPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
 	if err != nil {
 		return err
 	}
-	ivBytes, err := hex.DecodeString(strings.TrimPrefix(iv, "0x"))
-	if err != nil {
-		return err
-	}
-	segments.Key = keyBytes
-	segments.IV = ivBytes
-	return nil
+	_, err = f.Write(segBytes)
+	f.Close()
+	return err
 }
 
-func parseSegments(manifestUrl string) (*Segments, error) {
-	var segments Segments
-	req, err := client.Get(manifestUrl)
+// Decrypted segments are kept in workPath along with a manifest of the ones that are complete,
+// so an interrupted track only needs its missing segments fetched next time.
+func downloadSegments(ctx context.Context, client *beatport.Client, workPath string, playlist *Playlist, job *Job, trackNum int) ([]string, error) {
+	var segPaths []string
+	segTotal := len(playlist.Segments)
+	err := makeDirs(workPath)
 	if err != nil {
 		return nil, err
 	}
-	defer req.Body.Close()
-	if req.StatusCode != http.StatusOK {
-		return nil, errors.New(req.Status)
-	}
-	playlist, _, err := m3u8.DecodeFrom(req.Body, true)
+	manifest, err := readSegmentManifest(workPath, segTotal, playlist.Bitrate)
 	if err != nil {
 		return nil, err
 	}
-	baseUrl, err := getBaseUrl(manifestUrl)
-	if err != nil {
-		return nil, err
+	if len(manifest.Completed) > 0 {
+		job.logf("Resuming: %d of %d segments already downloaded.\n", len(manifest.Completed), segTotal)
 	}
-	media := playlist.(*m3u8.MediaPlaylist)
-	for i, segment := range media.Segments {
-		if segment == nil {
-			break
+	bar := progress.add(job.trackLabel(trackNum))
+	defer progress.remove(bar)
+	for segNum, segment := range playlist.Segments {
+		segNum++
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-		if i == 0 {
-			err := parseKeyIv(&segments, baseUrl+segment.Key.URI, segment.Key.IV)
+		segPath := filepath.Join(workPath, fmt.Sprintf("%03d.aac", segNum))
+		if manifest.isCompleted(segNum) {
+			exists, err := fileExists(segPath)
 			if err != nil {
 				return nil, err
 			}
+			if exists {
+				segPaths = append(segPaths, segPath)
+				continue
+			}
 		}
-		segments.SegmentUrls = append(segments.SegmentUrls, baseUrl+segment.URI)
-	}
-	return &segments, nil
-}
-
-func pkcs5Trimming(data []byte) []byte {
-	padding := data[len(data)-1]
-	return data[:len(data)-int(padding)]
-}
-
-func decryptSegment(segmentBytes, key, iv []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	cbc := cipher.NewCBCDecrypter(block, iv)
-	decrypted := make([]byte, len(segmentBytes))
-	cbc.CryptBlocks(decrypted, segmentBytes)
-	return pkcs5Trimming(decrypted), nil
-}
-
-func downloadSegments(tempPath string, segments *Segments) ([]string, error) {
-	var segPaths []string
-	segTotal := len(segments.SegmentUrls)
-	for segNum, segmentUrl := range segments.SegmentUrls {
-		segNum++
-		segPath := filepath.Join(tempPath, fmt.Sprintf("%03d.aac", segNum))
-		req, err := client.Get(segmentUrl)
-		if err != nil {
-			return nil, err
-		}
-		if req.StatusCode != http.StatusOK {
-			req.Body.Close()
-			return nil, errors.New(req.Status)
-		}
-		fmt.Printf("\rSegment %d of %d.", segNum, segTotal)
-		segBytes, err := ioutil.ReadAll(req.Body)
+		job.setSegment(trackNum, segNum, segTotal)
+		bar.setSegment(segNum, segTotal)
+		segBytes, err := getSegment(ctx, client, segment, bar)
 		if err != nil {
-			req.Body.Close()
 			return nil, err
 		}
-		decSegBytes, err := decryptSegment(segBytes, segments.Key, segments.IV)
-		if err != nil {
-			req.Body.Close()
-			return nil, err
+		if segment.Key != nil {
+			segBytes, err = decrypt.Segment(segBytes, segment.Key, segment.IV)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decrypt segment %d of %d.\n%s", segNum, segTotal, err)
+			}
 		}
-		f, err := os.OpenFile(segPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+		err = writeSegment(segPath, segBytes)
 		if err != nil {
-			req.Body.Close()
 			return nil, err
 		}
-		_, err = f.Write(decSegBytes)
-		req.Body.Close()
-		f.Close()
+		err = manifest.complete(workPath, segNum)
 		if err != nil {
 			return nil, err
 		}
 		segPaths = append(segPaths, segPath)
 	}
-	fmt.Println("")
 	return segPaths, nil
 }
 
@@ -604,7 +539,12 @@ func writeConcatFile(txtPath string, segPaths []string) error {
 	return nil
 }
 
-func concatSegments(trackPath, tempPath string, segPaths []string) error {
+// Runs FFmpeg and AtomicParsley. Swapped out by tests, so the rest of a download can run without them.
+var runTool = func(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+func concatSegments(ctx context.Context, trackPath, tempPath string, segPaths, codec []string) error {
 	txtPath := filepath.Join(tempPath, "tmp.txt")
 	defer cleanup(tempPath)
 	err := writeConcatFile(txtPath, segPaths)
@@ -613,11 +553,14 @@ func concatSegments(trackPath, tempPath string, segPaths []string) error {
 	}
 	var (
 		errBuffer bytes.Buffer
-		args      = []string{"-f", "concat", "-safe", "0", "-i", txtPath, "-c:a", "copy", trackPath}
+		args      = []string{"-y", "-f", "concat", "-safe", "0", "-i", txtPath}
 	)
-	cmd := exec.Command("ffmpeg", args...)
+	args = append(args, codec...)
+	args = append(args, "-f", "ipod", trackPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	setProcGroup(cmd)
 	cmd.Stderr = &errBuffer
-	err = cmd.Run()
+	err = runTool(cmd)
 	if err != nil {
 		errString := fmt.Sprintf("%s\n%s", err, errBuffer.String())
 		return errors.New(errString)
@@ -626,7 +569,7 @@ func concatSegments(trackPath, tempPath string, segPaths []string) error {
 }
 
 // Neither FFmpeg nor AtomicParsley support writing ISRC or UPC :(. Gib Go mp4 tag writing lib.
-func writeTags(trackPath, coverPath string, _tags map[string]string) error {
+func writeTags(ctx context.Context, trackPath, coverPath string, _tags map[string]string) error {
 	tags := map[string]string{
 		"album":       _tags["album"],
 		"albumArtist": _tags["albumArtist"],
@@ -637,13 +580,46 @@ func writeTags(trackPath, coverPath string, _tags map[string]string) error {
 		"tracknum":    _tags["track"] + "/" + _tags["trackTotal"],
 		"year":        _tags["year"],
 	}
+	if _tags["bitrate"] != "" {
+		tags["comment"] = "AAC " + _tags["bitrate"] + " kbps"
+	}
 	if coverPath != "" {
 		tags["artwork"] = coverPath
 	}
-	return ap.WriteTags(trackPath, tags)
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := []string{trackPath, "--overWrite"}
+	for _, key := range keys {
+		args = append(args, "--"+key, tags[key])
+	}
+	var errBuffer bytes.Buffer
+	cmd := exec.CommandContext(ctx, "AtomicParsley", args...)
+	setProcGroup(cmd)
+	cmd.Stderr = &errBuffer
+	err := runTool(cmd)
+	if err != nil {
+		errString := fmt.Sprintf("%s\n%s", err, errBuffer.String())
+		return errors.New(errString)
+	}
+	return nil
+}
+
+// Written next to the track as <track>.json.
+func writeSidecar(trackPath, trackId string, playlist *Playlist, tags map[string]string) error {
+	sidecar := &Sidecar{
+		TrackID:   trackId,
+		Bitrate:   playlist.Bitrate,
+		Bandwidth: playlist.Bandwidth,
+		Tags:      tags,
+	}
+	sidecarPath := strings.TrimSuffix(trackPath, filepath.Ext(trackPath)) + ".json"
+	return writeJsonAtomic(sidecarPath, sidecar)
 }
 
-func downloadCover(maxUrl, dynamicUrl, coverPath string, maxCover bool) error {
+func downloadCover(ctx context.Context, client *beatport.Client, maxUrl, dynamicUrl, coverPath string, maxCover bool) error {
 	var _url string
 	if maxCover {
 		_url = maxUrl
@@ -655,7 +631,7 @@ func downloadCover(maxUrl, dynamicUrl, coverPath string, maxCover bool) error {
 		return err
 	}
 	defer f.Close()
-	req, err := client.Get(_url)
+	req, err := client.Get(ctx, _url)
 	if err != nil {
 		return err
 	}
@@ -667,144 +643,383 @@ func downloadCover(maxUrl, dynamicUrl, coverPath string, maxCover bool) error {
 	return err
 }
 
-func init() {
-	fmt.Println(`
+func processAlbum(ctx context.Context, client *beatport.Client, cfg *Config, tempPath, albumId, ref string, job *Job) ([]*FilteredTrack, error) {
+	var filtered []*FilteredTrack
+	albumMeta, err := client.Release(ctx, albumId, ref)
+	if ctx.Err() != nil {
+		return nil, interruptErr(job)
+	} else if err != nil {
+		return nil, errors.New("Failed to get album metadata.\n" + err.Error())
+	}
+	sort.Strings(albumMeta.Tracks)
+	parsedAlbMeta := parseAlbumMeta(albumMeta)
+	albumFolder := parseTemplate(cfg.AlbumTemplate, albumTemplate, parsedAlbMeta)
+	job.log(parsedAlbMeta["albumArtist"] + " - " + parsedAlbMeta["album"])
+	if len(albumFolder) > 120 {
+		job.log("Album folder was chopped as it exceeds 120 characters.")
+		albumFolder = albumFolder[:120]
+	}
+	albumPath := filepath.Join(cfg.OutPath, sanitize(albumFolder))
+	err = makeDirs(albumPath)
+	if err != nil {
+		return nil, errors.New("Failed to make album folder.\n" + err.Error())
+	}
+	coverPath := filepath.Join(albumPath, "cover.jpg")
+	err = downloadCover(ctx, client, albumMeta.Image.URI, albumMeta.Image.DynamicURI, coverPath, cfg.MaxCover)
+	if err != nil {
+		job.handleErr("Failed to get cover.", err)
+		coverPath = ""
+	}
+	trackTotal := len(albumMeta.Tracks)
+	job.setAlbum(parsedAlbMeta["albumArtist"]+" - "+parsedAlbMeta["album"], albumMeta.Image.DynamicURI, trackTotal)
+	job.emit(&Event{Event: eventReleaseStarted, TrackTotal: trackTotal}, 0)
+	var interrupted bool
+	for trackNum, trackUrl := range albumMeta.Tracks {
+		trackNum++
+		if ctx.Err() != nil || isStopping() {
+			interrupted = true
+			break
+		}
+		trackId, err := getTrackId(trackUrl)
+		if err != nil {
+			job.trackFailed(trackNum, "Failed to get track ID.", err)
+			continue
+		}
+		// Tracks an interrupted run of this album finished with are left as they are.
+		saved := job.savedTrack(trackNum)
+		resumed := saved.ID == trackId
+		if resumed && saved.Status == trackTagged {
+			job.logf("Track %d of %d was downloaded by an earlier run: %s\n", trackNum, trackTotal, saved.Title)
+			job.emit(&Event{Event: eventTrackDone, Path: saved.Path}, trackNum)
+			continue
+		} else if resumed && saved.Status == trackSkipped {
+			job.logf("Track %d of %d was skipped by an earlier run: %s\n", trackNum, trackTotal, saved.Title)
+			job.emit(&Event{Event: eventTrackSkipped, Reason: "exists", Path: saved.Path}, trackNum)
+			continue
+		}
+		trackMeta, err := client.Track(ctx, trackId, ref)
+		if err != nil {
+			job.trackFailed(trackNum, "Failed to get track metadata.", err)
+			continue
+		}
+		job.setTrack(trackNum, trackId, trackMeta.Name+" ("+trackMeta.MixName+")")
+		reason := filterTrack(trackMeta, cfg.TrackFilters)
+		if reason != "" {
+			job.log("Track filtered: " + reason)
+			job.setTrackStatus(trackNum, trackFiltered, reason)
+			job.emit(&Event{Event: eventTrackSkipped, Reason: "filtered: " + reason}, trackNum)
+			filtered = append(filtered, &FilteredTrack{
+				Album:  parsedAlbMeta["album"],
+				Title:  trackMeta.Name + " (" + trackMeta.MixName + ")",
+				Reason: reason,
+			})
+			continue
+		}
+		parsedMeta, titleWithMixName := parseTrackMeta(trackMeta, parsedAlbMeta, trackNum, trackTotal, cfg.OmitOrigMix)
+		trackFname := parseTemplate(cfg.TrackTemplate, trackTemplate, parsedMeta)
+		sanTrackFname := sanitize(trackFname)
+		trackPath := filepath.Join(albumPath, sanTrackFname+trackExt)
+		job.setTrackPath(trackNum, trackPath)
+		exists, err := fileExists(trackPath)
+		if err != nil {
+			job.trackFailed(trackNum, "Failed to check if track already exists locally.", err)
+			continue
+		}
+		// An interrupted run can get as far as renaming the track into place before marking it tagged.
+		if exists && resumed && saved.Status == trackMuxing {
+			job.log("Track was finished by an earlier run.")
+			job.setTrackStatus(trackNum, trackTagged, "")
+			job.emit(&Event{Event: eventTrackDone, Path: trackPath}, trackNum)
+			continue
+		}
+		if exists {
+			job.log("Track already exists locally.")
+			job.setTrackStatus(trackNum, trackSkipped, "")
+			job.emit(&Event{Event: eventTrackSkipped, Reason: "exists", Path: trackPath}, trackNum)
+			continue
+		}
+		job.logf(
+			"Downloading track %d of %d: %s\n", trackNum, trackTotal, titleWithMixName,
+		)
+		if resumed && (saved.Status == trackDownloading || saved.Status == trackMuxing) {
+			job.logf("Resuming from its work folder, it was %s when the last run stopped.\n", saved.Status)
+		}
+		job.setTrackStatus(trackNum, trackDownloading, "")
+		workPath := filepath.Join(cfg.WorkPath, trackId)
+		err = downloadTrack(ctx, client, cfg, trackPath, tempPath, workPath, trackId, ref, coverPath, trackMeta, parsedMeta, job, trackNum)
+		// Its segments are kept, so it's left pending to be picked up again next time.
+		if ctx.Err() != nil {
+			job.setTrackStatus(trackNum, trackPending, "")
+			interrupted = true
+			break
+		} else if err != nil {
+			job.trackFailed(trackNum, "Failed to download track.", err)
+			continue
+		}
+		job.setTrackStatus(trackNum, trackTagged, "")
+		bitrate, _ := strconv.Atoi(parsedMeta["bitrate"])
+		job.emit(&Event{Event: eventTrackDone, Path: trackPath, Bitrate: bitrate}, trackNum)
+	}
+	if coverPath != "" && !cfg.KeepCover {
+		err := os.Remove(coverPath)
+		if err != nil {
+			job.handleErr("Failed to delete cover.", err)
+		}
+	}
+	if interrupted {
+		return filtered, interruptErr(job)
+	}
+	return filtered, nil
+}
+
+func downloadTrack(ctx context.Context, client *beatport.Client, cfg *Config, trackPath, tempPath, workPath, trackId, ref, coverPath string, trackMeta *beatport.Track, parsedMeta map[string]string, job *Job, trackNum int) error {
+	stream, err := client.Stream(ctx, trackId, ref, trackMeta.SampleEndMs)
+	if err != nil {
+		return errors.New("Failed to get track stream URL.\n" + err.Error())
+	}
+	playlist, err := probeStream(ctx, client, stream.StreamURL, cfg.Quality, job)
+	if err != nil {
+		return errors.New("Failed to parse segments.\n" + err.Error())
+	}
+	// Left out of the tags and sidecar if it's not known.
+	if playlist.Bitrate > 0 {
+		job.logf("AAC %d\n", playlist.Bitrate)
+		parsedMeta["bitrate"] = strconv.Itoa(playlist.Bitrate)
+	} else {
+		job.log("AAC, bitrate unknown")
+	}
+	var (
+		segPaths []string
+		audio    *AudioInfo
+	)
+	// A track that fails validation is re-downloaded from scratch, up to cfg.Redownloads times.
+	for attempt := 0; ; attempt++ {
+		segPaths, err = downloadSegments(ctx, client, workPath, playlist, job, trackNum)
+		if err != nil {
+			return errors.New("Failed to download segments.\n" + err.Error())
+		}
+		audio, err = validateSegments(segPaths, playlist.Segments, trackMeta.LengthMs)
+		if err == nil {
+			job.logf(
+				"%d frames, %d Hz, %d channel(s), %s.\n",
+				audio.Frames, audio.SampleRate, audio.Channels, audio.Duration.Round(time.Millisecond),
+			)
+			break
+		}
+		// Its segments are no good, so they're dropped either way rather than resumed from next time.
+		rmErr := os.RemoveAll(workPath)
+		if attempt >= cfg.Redownloads {
+			return errors.New("Failed to validate track.\n" + err.Error())
+		}
+		job.handleErr("Track failed validation, re-downloading it.", err)
+		if rmErr != nil {
+			return errors.New("Failed to delete work folder.\n" + rmErr.Error())
+		}
+	}
+	// One AAC track can't change format part way through, so it's re-encoded to the first segment's if it does.
+	codec := []string{"-c:a", "copy"}
+	if audio.Changed {
+		job.logf("Format changes at a discontinuity, re-encoding to %d Hz, %d channel(s).\n", audio.SampleRate, audio.Channels)
+		codec = []string{"-c:a", "aac", "-ar", strconv.Itoa(audio.SampleRate), "-ac", strconv.Itoa(audio.Channels)}
+		// Otherwise FFmpeg's default.
+		if playlist.Bitrate > 0 {
+			codec = append(codec, "-b:a", strconv.Itoa(playlist.Bitrate)+"k")
+		}
+	}
+	// Muxed and tagged under a .part name so a half-written file is never mistaken for a finished one.
+	job.setTrackStatus(trackNum, trackMuxing, "")
+	partPath := trackPath + partExt
+	err = finaliseTrack(ctx, trackPath, partPath, tempPath, coverPath, segPaths, codec, parsedMeta)
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	if cfg.Sidecar {
+		err = writeSidecar(trackPath, trackId, playlist, parsedMeta)
+		if err != nil {
+			job.handleErr("Failed to write sidecar.", err)
+		}
+	}
+	err = os.RemoveAll(workPath)
+	if err != nil {
+		job.handleErr("Failed to delete work folder.", err)
+	}
+	return nil
+}
+
+func printBanner() {
+	fmt.Print(`
  _____         _               _      ____                _           _         
 | __  |___ ___| |_ ___ ___ ___| |_   |    \ ___ _ _ _ ___| |___ ___ _| |___ ___ 
 | __ -| -_| .'|  _| . | . |  _|  _|  |  |  | . | | | |   | | . | .'| . | -_|  _|
 |_____|___|__,|_| |  _|___|_| |_|    |____/|___|_____|_|_|_|___|__,|___|___|_|  
                   |_|
-`)
+` + "\n")
 }
 
-func main() {
-	scriptDir, err := getScriptDir()
+func run() error {
+	var (
+		err        error
+		cfg        *Config
+		watchArgs  *WatchArgs
+		daemonArgs *DaemonArgs
+		subcommand string
+	)
+	if len(os.Args) > 1 {
+		subcommand = os.Args[1]
+	}
+	switch subcommand {
+	case "config":
+		return runConfigCmd()
+	case "account":
+		return runAccountCmd()
+	case "fakeserver":
+		return runFakeServerCmd()
+	}
+	switch subcommand {
+	case "watch":
+		cfg, watchArgs, err = parseWatchCfg()
+	case "daemon":
+		cfg, daemonArgs, err = parseDaemonCfg()
+	default:
+		cfg, err = parseCfg()
+	}
 	if err != nil {
-		panic(err)
+		return wrapConfigErr("Failed to parse config file.", err)
 	}
-	tempPath, err := getTempPath()
+	if cfg.JSON {
+		setupEvents()
+	}
+	err = setupTransport(cfg)
 	if err != nil {
-		panic(err)
+		return configErr("Failed to set up HTTP transport.", err)
+	}
+	client := newClient(cfg)
+	if cfg.BaseUrl != "" {
+		siteUrls = append(siteUrls, cfg.BaseUrl)
+	}
+	printBanner()
+	// Before Ctrl+C is caught, so it still quits at the prompt.
+	if cfg.CookiesPath == "" {
+		err = getCredentials(cfg)
+		if err != nil {
+			return authErr("Failed to get credentials.", err)
+		}
 	}
-	err = os.Chdir(scriptDir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
+	err = makeDirs(cfg.OutPath)
 	if err != nil {
-		panic(err)
+		return configErr("Failed to make output path.", err)
 	}
-	cfg, err := parseCfg()
+	err = makeStateDirs(cfg)
 	if err != nil {
-		handleErr("Failed to parse config file.", err, true)
+		return configErr("Failed to make state dir.", err)
 	}
-	err = makeDirs(cfg.OutPath)
+	removed, err := cleanPartFiles(cfg.OutPath)
 	if err != nil {
-		handleErr("Failed to make output path.", err, true)
+		handleErr("Failed to clean up stale .part files.", err)
+	} else if removed > 0 {
+		fmt.Printf("Removed %d stale .part file(s) from an interrupted run.\n", removed)
 	}
-	err = auth(cfg.Email, cfg.Password)
+	if cfg.Profile != "" {
+		fmt.Println("Using profile " + cfg.Profile + ".")
+	}
+	err = signIn(ctx, client, cfg)
 	if err != nil {
-		handleErr("Failed to auth.", err, true)
+		return authErr("Failed to auth.", err)
 	}
-	plan, err := getPlan()
+	sub, err := client.Subscription(ctx)
 	if err != nil {
-		handleErr("Failed to get subscription info.", err, true)
+		return authErr("Failed to get subscription info.", err)
 	}
-	if !strings.Contains(plan, "LINK") {
-		panic("LINK or LINK Pro subscription required.")
+	plan := sub.PlanName()
+	fmt.Println("Signed in successfully - " + plan)
+	err = checkPlan(sub, cfg)
+	if err != nil {
+		return noSubErr(err.Error())
 	}
-	fmt.Println("Signed in successfully - " + plan + "\n")
-	albumTotal := len(cfg.Urls)
-	for albumNum, _url := range cfg.Urls {
-		fmt.Printf("Album %d of %d:\n", albumNum+1, albumTotal)
-		albumId := checkUrl(_url)
-		if albumId == "" {
+	fmt.Println()
+	mode := "download"
+	if subcommand == "watch" {
+		mode = "watch"
+	}
+	emit(&Event{Event: eventRunStarted, Mode: mode, Plan: plan})
+	tempPath, err := getTempPath()
+	if err != nil {
+		return fatalErr("Failed to make temp folder.", err)
+	}
+	defer os.RemoveAll(tempPath)
+	switch subcommand {
+	case "watch":
+		return watch(ctx, client, cfg, watchArgs, tempPath)
+	case "daemon":
+		return runDaemon(ctx, client, cfg, daemonArgs, tempPath)
+	}
+	var (
+		filtered []*FilteredTrack
+		summary  RunSummary
+	)
+	jobs, err := newJobStore(cfg.QueuePath)
+	if err != nil {
+		return fatalErr("Failed to read queue.", err)
+	}
+	defer jobs.close()
+	// Albums left over from a run that didn't finish go first.
+	queue := jobs.unfinished(cfg.Profile)
+	if len(queue) > 0 {
+		fmt.Printf("Resuming %d unfinished album(s) from the last run.\n\n", len(queue))
+	}
+	for _, _url := range cfg.Urls {
+		if checkUrl(_url) == "" {
 			fmt.Println("Invalid URL:", _url)
+			emitError("url", errors.New("Invalid URL: "+_url))
+			summary.FailedAlbums++
 			continue
 		}
-		albumMeta, err := getAlbumMeta(albumId, _url)
-		if err != nil {
-			handleErr("Failed to get album metadata.", err, false)
-			continue
-		}
-		parsedAlbMeta := parseAlbumMeta(albumMeta)
-		albumFolder := parseTemplate(cfg.AlbumTemplate, albumTemplate, parsedAlbMeta)
-		fmt.Println(parsedAlbMeta["albumArtist"] + " - " + parsedAlbMeta["album"])
-		if len(albumFolder) > 120 {
-			fmt.Println("Album folder was chopped as it exceeds 120 characters.")
-			albumFolder = albumFolder[:120]
-		}
-		albumPath := filepath.Join(cfg.OutPath, sanitize(albumFolder))
-		err = makeDirs(albumPath)
-		if err != nil {
-			handleErr("Failed to make album folder.", err, false)
-			continue
+		if jobs.find(_url, cfg.Profile) == nil {
+			queue = append(queue, jobs.add(_url, cfg.Profile))
 		}
-		coverPath := filepath.Join(albumPath, "cover.jpg")
-		err = downloadCover(albumMeta.Image.URI, albumMeta.Image.DynamicURI, coverPath, cfg.MaxCover)
-		if err != nil {
-			handleErr("Failed to get cover.", err, false)
-			coverPath = ""
-		}
-		trackTotal := len(albumMeta.Tracks)
-		for trackNum, trackUrl := range albumMeta.Tracks {
-			trackNum++
-			trackId, err := getTrackId(trackUrl)
-			if err != nil {
-				handleErr("Failed to get track ID.", err, false)
-				continue
-			}
-			trackMeta, err := getTrackMeta(trackId, _url)
-			if err != nil {
-				handleErr("Failed to get track metadata.", err, false)
-				continue
-			}
-			parsedMeta, titleWithMixName := parseTrackMeta(trackMeta, parsedAlbMeta, trackNum, trackTotal, cfg.OmitOrigMix)
-			trackFname := parseTemplate(cfg.TrackTemplate, trackTemplate, parsedMeta)
-			sanTrackFname := sanitize(trackFname)
-			trackPath := filepath.Join(albumPath, sanTrackFname+".m4a")
-			exists, err := fileExists(trackPath)
-			if err != nil {
-				handleErr("Failed to check if track already exists locally.", err, false)
-				continue
-			}
-			if err != nil {
-				handleErr("Failed to check if track already exists locally.", err, false)
-				continue
-			}
-			if exists {
-				fmt.Println("Track already exists locally.")
-				continue
-			}
-			fmt.Printf(
-				"Downloading track %d of %d: %s - AAC 256\n", trackNum, trackTotal, titleWithMixName,
-			)
-			streamUrl, err := getTrackStreamUrl(trackId, _url, trackMeta.SampleEndMs)
-			if err != nil {
-				handleErr("Failed to get track stream URL.", err, false)
-				continue
-			}
-			segments, err := parseSegments(streamUrl)
-			if err != nil {
-				handleErr("Failed to parse segments.", err, false)
-				continue
-			}
-			segPaths, err := downloadSegments(tempPath, segments)
-			if err != nil {
-				handleErr("Failed to download segments.", err, false)
-				continue
-			}
-			err = concatSegments(trackPath, tempPath, segPaths)
-			if err != nil {
-				handleErr("Failed to concat segments.", err, false)
-				continue
-			}
-			err = writeTags(trackPath, coverPath, parsedMeta)
-			if err != nil {
-				handleErr("Failed to write tags.", err, false)
-			}
+	}
+	albumTotal := len(queue)
+	var interrupted bool
+	for albumNum, job := range queue {
+		if isStopping() {
+			interrupted = true
+			break
 		}
-		if coverPath != "" && !cfg.KeepCover {
-			err := os.Remove(coverPath)
-			if err != nil {
-				handleErr("Failed to delete cover.", err, false)
-			}
+		fmt.Printf("Album %d of %d:\n", albumNum+1, albumTotal)
+		progress.setBatch(albumNum, albumTotal)
+		job.setStatus(jobRunning, nil)
+		albumFiltered, err := processAlbum(ctx, client, cfg, tempPath, checkUrl(job.Url), job.Url, job)
+		filtered = append(filtered, albumFiltered...)
+		summary.addJob(job, err)
+		// Left in the queue to be resumed.
+		if err == errInterrupted {
+			interrupted = true
+			break
+		} else if err != nil {
+			fmt.Println(err)
+			job.emit(&Event{Event: eventError, Context: "release", Error: err.Error()}, 0)
 		}
+		jobs.remove(job)
+	}
+	printFilterReport(filtered)
+	summary.print()
+	emit(&Event{Event: eventRunFinished, Summary: &summary})
+	printResumeSummary(jobs, cfg.Profile)
+	if interrupted {
+		return errInterrupted
+	}
+	return summary.err()
+}
+
+func main() {
+	err := run()
+	if err != nil && err != errInterrupted && err != arg.ErrHelp {
+		fmt.Fprintln(os.Stderr, err)
+		emitError("fatal", err)
 	}
+	os.Exit(exitCode(err))
 }
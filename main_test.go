@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+	"github.com/Sorrow446/Beatport-Downloader/fakeserver"
+)
+
+var mp4Header = []byte("\x00\x00\x00\x08ftyp")
+
+// Stands in for FFmpeg and AtomicParsley. FFmpeg's concat list is joined after an MP4 header, so what was
+// muxed can be checked, and AtomicParsley's args are kept.
+type fakeTools struct {
+	mu   sync.Mutex
+	tags [][]string
+}
+
+func (f *fakeTools) run(cmd *exec.Cmd) error {
+	args := cmd.Args[1:]
+	switch filepath.Base(cmd.Path) {
+	case "ffmpeg":
+		var listPath string
+		for i, arg := range args {
+			if arg == "-i" {
+				listPath = args[i+1]
+			}
+		}
+		list, err := os.Open(listPath)
+		if err != nil {
+			return err
+		}
+		defer list.Close()
+		data := append([]byte{}, mp4Header...)
+		scanner := bufio.NewScanner(list)
+		for scanner.Scan() {
+			segPath := strings.TrimSuffix(strings.TrimPrefix(scanner.Text(), "file '"), "'")
+			segBytes, err := ioutil.ReadFile(segPath)
+			if err != nil {
+				return err
+			}
+			data = append(data, segBytes...)
+		}
+		return ioutil.WriteFile(args[len(args)-1], data, 0644)
+	case "AtomicParsley":
+		f.mu.Lock()
+		f.tags = append(f.tags, args)
+		f.mu.Unlock()
+		return nil
+	}
+	return errors.New("Unexpected command: " + cmd.Path)
+}
+
+func TestProcessAlbum(t *testing.T) {
+	srv, err := fakeserver.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+	tools := &fakeTools{}
+	runTool = tools.run
+	defer func() {
+		runTool = func(cmd *exec.Cmd) error {
+			return cmd.Run()
+		}
+	}()
+	dir := t.TempDir()
+	cfg := &Config{
+		OutPath:  filepath.Join(dir, "out"),
+		WorkPath: filepath.Join(dir, "work"),
+		BaseUrl:  httpSrv.URL + "/",
+	}
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	err = setCfgDefaults(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempPath := filepath.Join(dir, "temp")
+	err = makeDirs(tempPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(cfg)
+	ctx := context.Background()
+	err = client.Login(ctx, srv.Email, srv.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := newJobStore("")
+	releaseUrl := cfg.BaseUrl + "release/offline-ep/1"
+	job := jobs.add(releaseUrl, "")
+	_, err = processAlbum(ctx, client, cfg, tempPath, "1", releaseUrl, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = job.trackErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	albumPath := filepath.Join(cfg.OutPath, "Fake Artist - Offline EP")
+	tracks := []struct {
+		id       string
+		fname    string
+		lengthMs int
+		title    string
+	}{
+		{"101", "01. First Light (Original Mix).m4a", 9000, "First Light (Original Mix)"},
+		{"102", "02. Second Wind (Extended Mix).m4a", 13000, "Second Wind (Extended Mix)"},
+	}
+	for i, track := range tracks {
+		data, err := ioutil.ReadFile(filepath.Join(albumPath, track.fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every segment, decrypted and in order.
+		want := append([]byte{}, mp4Header...)
+		for num := 0; ; num++ {
+			audio := fakeserver.Audio(track.lengthMs, num)
+			if len(audio) == 0 {
+				break
+			}
+			want = append(want, audio...)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("%s: got %d byte(s) of audio, want %d", track.fname, len(data), len(want))
+		}
+		tags := strings.Join(tools.tags[i], "\x00")
+		for _, tag := range []string{"--title\x00" + track.title, "--tracknum\x00" + track.id[2:] + "/2", "--comment\x00AAC 256 kbps"} {
+			if !strings.Contains(tags, tag) {
+				t.Errorf("%s: tags %q don't hold %q", track.fname, tools.tags[i], tag)
+			}
+		}
+		_, err = os.Stat(filepath.Join(cfg.WorkPath, track.id))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s: work folder wasn't removed", track.fname)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(albumPath, "*"))
+	if len(files) != len(tracks) {
+		t.Errorf("got files %v, want only the tracks", files)
+	}
+
+	// Run again, everything's already there.
+	job = jobs.add(releaseUrl, "")
+	_, err = processAlbum(ctx, client, cfg, tempPath, "1", releaseUrl, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(job.Tracks) != len(tracks) {
+		t.Fatalf("got %d track(s) on the second run, want %d", len(job.Tracks), len(tracks))
+	}
+	for _, track := range job.Tracks {
+		if track.Status != trackSkipped {
+			t.Errorf("track %d: got status %s on the second run, want %s", track.Num, track.Status, trackSkipped)
+		}
+	}
+
+	// Resume the first run's job, its tracks are tagged so they're neither downloaded nor skipped.
+	job = jobs.get(1)
+	tagged := len(tools.tags)
+	_, err = processAlbum(ctx, client, cfg, tempPath, "1", releaseUrl, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools.tags) != tagged {
+		t.Errorf("resumed tracks were tagged again")
+	}
+	var summary RunSummary
+	summary.addJob(job, nil)
+	if summary.Downloaded != len(tracks) || summary.Skipped != 0 {
+		t.Errorf("got %+v resuming, want %d downloaded", summary, len(tracks))
+	}
+}
+
+// Releases and tracks with nothing filled in mustn't panic, as that'd take the daemon down with them.
+func TestParseMetaMissing(t *testing.T) {
+	albMeta := parseAlbumMeta(&beatport.Release{})
+	parsedMeta, _ := parseTrackMeta(&beatport.Track{}, albMeta, 1, 1, false)
+	for _, key := range []string{"albumArtist", "artist", "year"} {
+		value, ok := parsedMeta[key]
+		if !ok || value != "" {
+			t.Errorf("got %s %q, want it empty", key, value)
+		}
+	}
+	if _, ok := parsedMeta["upc"]; ok {
+		t.Error("got a UPC")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	trackExt = ".m4a"
+	partExt  = ".part"
+	// Newer .part files could belong to another instance or the daemon that's muxing right now.
+	stalePartAge = 10 * time.Minute
+)
+
+// Cheap sanity check that FFmpeg and AtomicParsley left us with an MP4 and not a stub.
+func verifyTrack(trackPath string) error {
+	f, err := os.Open(trackPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, 8)
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return errors.New("Track is truncated.")
+	}
+	if !bytes.Equal(header[4:], []byte("ftyp")) {
+		return errors.New("Track isn't an MP4 file.")
+	}
+	return nil
+}
+
+func finaliseTrack(ctx context.Context, trackPath, partPath, tempPath, coverPath string, segPaths, codec []string, parsedMeta map[string]string) error {
+	err := concatSegments(ctx, partPath, tempPath, segPaths, codec)
+	if err != nil {
+		return errors.New("Failed to concat segments.\n" + err.Error())
+	}
+	err = writeTags(ctx, partPath, coverPath, parsedMeta)
+	if err != nil {
+		return errors.New("Failed to write tags.\n" + err.Error())
+	}
+	err = verifyTrack(partPath)
+	if err != nil {
+		return errors.New("Failed to verify track.\n" + err.Error())
+	}
+	err = os.Rename(partPath, trackPath)
+	if err != nil {
+		return errors.New("Failed to move track into place.\n" + err.Error())
+	}
+	return nil
+}
+
+// .part files are only ever left behind by a run that died mid-mux or mid-tag.
+// The segments they were made from are still in the work folder, so the track gets re-muxed from those.
+func cleanPartFiles(outPath string) (int, error) {
+	var removed int
+	err := filepath.Walk(outPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Not other programs' .part files, e.g. a browser's.
+		if info.IsDir() || !strings.HasSuffix(info.Name(), trackExt+partExt) {
+			return nil
+		}
+		if time.Since(info.ModTime()) < stalePartAge {
+			return nil
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
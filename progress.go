@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	barWidth   = 20
+	labelWidth = 32
+	// How often bars are redrawn on a terminal, and how often a plain line is printed otherwise.
+	renderInterval = 100 * time.Millisecond
+	plainInterval  = 5 * time.Second
+)
+
+var progress = &Progress{}
+
+// Close enough without a terminal library. /dev/null is a character device too, so it's ruled out.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	nullInfo, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, nullInfo)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+func drawBar(fraction float64) string {
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * barWidth)
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]"
+}
+
+func fitLabel(label string) string {
+	runes := []rune(label)
+	if len(runes) > labelWidth {
+		return string(runes[:labelWidth-3]) + "..."
+	}
+	return label + strings.Repeat(" ", labelWidth-len(runes))
+}
+
+// Albums done out of the total for the whole run. Only the CLI sets this.
+func (p *Progress) setBatch(albumsDone, albumTotal int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.albumsDone = albumsDone
+	p.albumTotal = albumTotal
+	p.tracksDone = 0
+	p.trackTotal = 0
+}
+
+func (p *Progress) setTracks(tracksDone, trackTotal int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tracksDone = tracksDone
+	p.trackTotal = trackTotal
+}
+
+func (p *Progress) add(label string) *ProgressBar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.checked {
+		p.tty = isTerminal(os.Stdout)
+		p.checked = true
+	}
+	bar := &ProgressBar{Label: label, progress: p, started: time.Now()}
+	p.bars = append(p.bars, bar)
+	return bar
+}
+
+// Draws the bar one last time as a plain line, so finished tracks stay in the scrollback.
+func (p *Progress) remove(bar *ProgressBar) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clearLocked()
+	for i, b := range p.bars {
+		if b == bar {
+			p.bars = append(p.bars[:i], p.bars[i+1:]...)
+			break
+		}
+	}
+	bar.finished = true
+	if bar.Bytes > 0 {
+		fmt.Println(bar.line())
+	}
+	p.renderLocked(true)
+}
+
+// Wipes the bars so other output can be printed, they're redrawn below it on the next update.
+func (p *Progress) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clearLocked()
+}
+
+func (p *Progress) clearLocked() {
+	if p.lines == 0 {
+		return
+	}
+	fmt.Printf("\x1b[%dA\x1b[J", p.lines)
+	p.lines = 0
+}
+
+func (p *Progress) batchLine() string {
+	fraction := float64(p.albumsDone)
+	if p.trackTotal > 0 {
+		fraction += float64(p.tracksDone) / float64(p.trackTotal)
+	}
+	fraction /= float64(p.albumTotal)
+	return fmt.Sprintf(
+		"%s %s %3.0f%%", fitLabel(fmt.Sprintf("Album %d of %d", p.albumsDone+1, p.albumTotal)),
+		drawBar(fraction), fraction*100,
+	)
+}
+
+func (p *Progress) renderLocked(force bool) {
+	if len(p.bars) == 0 {
+		return
+	}
+	now := time.Now()
+	interval := renderInterval
+	if !p.tty {
+		interval = plainInterval
+	}
+	if !force && now.Sub(p.rendered) < interval {
+		return
+	}
+	p.rendered = now
+	if !p.tty {
+		for _, bar := range p.bars {
+			fmt.Println(bar.line())
+		}
+		return
+	}
+	p.clearLocked()
+	var lines []string
+	for _, bar := range p.bars {
+		lines = append(lines, bar.line())
+	}
+	if p.albumTotal > 1 {
+		lines = append(lines, p.batchLine())
+	}
+	for _, line := range lines {
+		fmt.Print("\r\x1b[2K" + line + "\n")
+	}
+	p.lines = len(lines)
+}
+
+// The total's estimated from the average size of the segments fetched so far.
+func (b *ProgressBar) estimate() (int64, time.Duration, float64) {
+	elapsed := time.Since(b.started).Seconds()
+	var speed float64
+	if elapsed > 0 {
+		speed = float64(b.Bytes) / elapsed
+	}
+	if b.finished {
+		return b.Bytes, 0, speed
+	}
+	if b.fetched == 0 {
+		return 0, 0, speed
+	}
+	doneBytes := b.Bytes - b.segBytes
+	perSeg := doneBytes / int64(b.fetched)
+	// The current segment's own bytes are still coming in.
+	current := perSeg
+	if b.segBytes > current {
+		current = b.segBytes
+	}
+	total := doneBytes + current + perSeg*int64(b.SegmentTotal-b.Segment)
+	var eta time.Duration
+	if speed > 0 {
+		eta = time.Duration(float64(total-b.Bytes) / speed * float64(time.Second))
+	}
+	return total, eta, speed
+}
+
+func (b *ProgressBar) line() string {
+	total, eta, speed := b.estimate()
+	if total == 0 {
+		return fmt.Sprintf("%s %s segment %d of %d", fitLabel(b.Label), drawBar(0), b.Segment, b.SegmentTotal)
+	}
+	fraction := float64(b.Bytes) / float64(total)
+	return fmt.Sprintf(
+		"%s %s %3.0f%% %s of %s %s/s ETA %s", fitLabel(b.Label), drawBar(fraction), fraction*100,
+		formatBytes(b.Bytes), formatBytes(total), formatBytes(int64(speed)), formatDuration(eta),
+	)
+}
+
+func (b *ProgressBar) setSegment(segNum, segTotal int) {
+	b.progress.mu.Lock()
+	defer b.progress.mu.Unlock()
+	if b.segBytes > 0 {
+		b.fetched++
+	}
+	b.Segment = segNum
+	b.SegmentTotal = segTotal
+	b.segBytes = 0
+	b.progress.renderLocked(false)
+}
+
+// Segment bodies are teed through here as they download.
+func (b *ProgressBar) Write(data []byte) (int, error) {
+	b.progress.mu.Lock()
+	defer b.progress.mu.Unlock()
+	b.Bytes += int64(len(data))
+	b.segBytes += int64(len(data))
+	b.progress.renderLocked(false)
+	return len(data), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+const defQuality = 256
+
+// AAC bitrates Beatport serves, best first.
+var qualities = []int{256, 128}
+
+func checkQuality(quality int) error {
+	for _, q := range qualities {
+		if q == quality {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid quality: %d. Must be 256 or 128.", quality)
+}
+
+// The stream URL the API hands out always points at the 128k rendition.
+func getQualityUrl(streamUrl string, quality int) (string, bool) {
+	if !strings.Contains(streamUrl, ".128k.") {
+		return streamUrl, false
+	}
+	return strings.Replace(streamUrl, ".128k.", fmt.Sprintf(".%dk.", quality), 1), true
+}
+
+// Tries the preferred quality's manifest first, then each lower one.
+func probeStream(ctx context.Context, client *beatport.Client, streamUrl string, quality int, job *Job) (*Playlist, error) {
+	var lastErr error
+	for _, q := range qualities {
+		if q > quality {
+			continue
+		}
+		qualityUrl, ok := getQualityUrl(streamUrl, q)
+		// Unknown URL format, so take whatever it is and go by the manifest's bandwidth.
+		// Media playlists don't have one, which leaves the bitrate at 0, unknown.
+		if !ok {
+			playlist, err := parseSegments(ctx, client, streamUrl)
+			if err != nil {
+				return nil, err
+			}
+			playlist.Bitrate = int(playlist.Bandwidth / 1000)
+			job.log("Couldn't pick a quality from the stream URL, using it as is.")
+			return playlist, nil
+		}
+		playlist, err := parseSegments(ctx, client, qualityUrl)
+		if err == nil {
+			playlist.Bitrate = q
+			if q != quality {
+				job.logf("Falling back to AAC %d as AAC %d isn't available.\n%s\n", q, quality, lastErr)
+			}
+			return playlist, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("No qualities to try.")
+	}
+	return nil, lastErr
+}
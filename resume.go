@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const manifestFname = "manifest.json"
+
+// Segments from a previous attempt are only reused if the segment count and bitrate still match,
+// otherwise the work dir is emptied and the track starts over.
+func readSegmentManifest(workPath string, segTotal, bitrate int) (*SegmentManifest, error) {
+	manifest := &SegmentManifest{Total: segTotal, Bitrate: bitrate}
+	data, err := ioutil.ReadFile(filepath.Join(workPath, manifestFname))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var prev SegmentManifest
+	err = json.Unmarshal(data, &prev)
+	if err != nil || prev.Total != segTotal || prev.Bitrate != bitrate {
+		cleanup(workPath)
+		return manifest, nil
+	}
+	return &prev, nil
+}
+
+func (m *SegmentManifest) isCompleted(segNum int) bool {
+	for _, completed := range m.Completed {
+		if completed == segNum {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *SegmentManifest) complete(workPath string, segNum int) error {
+	m.Completed = append(m.Completed, segNum)
+	sort.Ints(m.Completed)
+	return writeJsonAtomic(filepath.Join(workPath, manifestFname), m)
+}
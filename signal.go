@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	errInterrupted = errors.New("Interrupted.")
+	// Closed on the first Ctrl+C.
+	stopping = make(chan struct{})
+)
+
+func isStopping() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Tells a job the user cancelled apart from one caught up in the whole run stopping.
+func interruptErr(job *Job) error {
+	if job.isCancelled() {
+		return errJobCancelled
+	}
+	return errInterrupted
+}
+
+// The first Ctrl+C lets the current track finish and then stops, the second aborts it.
+// Either way its segments are kept so the next run can resume it.
+func handleSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		close(stopping)
+		progress.clear()
+		fmt.Println("\nStopping after the current track. Press Ctrl+C again to abort it.")
+		<-sigs
+		progress.clear()
+		fmt.Println("\nAborting.")
+		cancel()
+	}()
+}
+
+func printResumeSummary(jobs *JobStore, profile string) {
+	unfinished := jobs.unfinished(profile)
+	if len(unfinished) == 0 {
+		return
+	}
+	fmt.Printf("\nStopped with %d album(s) left. Run again to resume:\n", len(unfinished))
+	for _, job := range unfinished {
+		done, total := job.trackCounts()
+		if total == 0 {
+			fmt.Println(job.Url)
+			continue
+		}
+		fmt.Printf("%s (%d of %d tracks done)\n", job.Url, done, total)
+	}
+}
@@ -1,251 +1,312 @@
 package main
 
-type Transport struct{}
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
 
 type Config struct {
-	Email         string
-	Password      string
-	Urls          []string
-	OutPath       string
-	AlbumTemplate string
-	TrackTemplate string
-	MaxCover      bool
-	OmitOrigMix   bool
-	KeepCover     bool
+	Email           string
+	Password        string `secret:"true"`
+	PasswordCommand string
+	PasswordFile    string
+	CookiesPath     string
+	Urls            []string
+	OutPath         string
+	AlbumTemplate   string
+	TrackTemplate   string
+	MaxCover        bool
+	OmitOrigMix     bool
+	KeepCover       bool
+	Filters         []string
+	QueuePath       string
+	WorkPath        string
+	Quality         int
+	Sidecar         bool
+	Redownloads     int
+	BaseUrl         string
+	ApiUrl          string
+	Profile         string
+	Profiles        map[string]*Profile
+	TrackFilters    []*TrackFilter `json:"-"`
+	JSON            bool           `json:"-"`
+	RecordPath      string         `json:"-"`
+	ReplayPath      string         `json:"-"`
+	TracePath       string         `json:"-"`
+	Watch           WatchConfig
+	Daemon          DaemonConfig
+	Path            string `json:"-"`
+}
+
+// Settings that differ between accounts. Set ones override the top level option of the same name.
+type Profile struct {
+	Email           string
+	Password        string `secret:"true"`
+	PasswordCommand string
+	PasswordFile    string
+	CookiesPath     string
+	OutPath         string
+	AlbumTemplate   string
+	TrackTemplate   string
+}
+
+type WatchConfig struct {
+	Interval  int
+	Labels    []string
+	Artists   []string
+	StatePath string
+}
+
+// Flags for every config option, shared by all commands. Set ones override the field of the same name
+// in Config, or the one named by the cfg tag. Ints and bools are pointers so 0 and false can be given too.
+type CommonArgs struct {
+	Config          string   `arg:"-c, --config" help:"Config file to use. Looked for in the usual places if not given." cfg:"-"`
+	Profile         string   `arg:"-P, --profile" help:"Profile from the config file to use." cfg:"-"`
+	Email           string   `arg:"-e" help:"Email address."`
+	Password        string   `arg:"-p" help:"Password."`
+	PasswordCommand string   `arg:"--passwordcommand" help:"Command that prints the password, e.g. pass show beatport."`
+	PasswordFile    string   `arg:"--passwordfile" help:"File holding the password. Must only be readable by its owner."`
+	CookiesPath     string   `arg:"--cookies" help:"Sign in with a cookies.txt exported from a browser that's signed in to Beatport, instead of an email and password."`
+	OutPath         string   `arg:"-o" help:"Where to download to. Path will be made if it doesn't already exist."`
+	MaxCover        *bool    `arg:"-m" help:"true = max cover size, false = 600x600."`
+	OmitOrigMix     *bool    `arg:"--omitorigmix" help:"Omit mix type from track filenames and tags if it's an original mix."`
+	KeepCover       *bool    `arg:"-k" help:"Don't delete covers from album folders."`
+	AlbumTemplate   string   `arg:"-a" help:"Album folder naming template. Vars: album, albumArtist, catalogNumber, upc, year."`
+	TrackTemplate   string   `arg:"-t" help:"Track filename naming template. Vars: album, albumArtist, artist, bpm, genre, isrc, title, track, trackPad, trackTotal, year."`
+	Filters         []string `arg:"-f, --filter, separate" help:"Only download tracks matching field=value. Fields: bpm, key, genre, sub_genre, exclusive, is_hype, available_worldwide, is_available_for_streaming."`
+	Quality         *int     `arg:"-q" help:"Preferred AAC bitrate, 256 or 128. Falls back to 128 if 256 isn't available."`
+	Sidecar         *bool    `arg:"-s" help:"Write a .json file next to each track with its tags and the bitrate it was downloaded in."`
+	Redownloads     *int     `arg:"-r" help:"How many times to re-download a track that's truncated or corrupt."`
+	QueuePath       string   `arg:"--queuepath" help:"Where the download queue is kept."`
+	WorkPath        string   `arg:"--workpath" help:"Where decrypted segments are kept while a track downloads."`
+	BaseUrl         string   `arg:"--baseurl" help:"Site to use instead of https://www.beatport.com/, e.g. a fake server."`
+	ApiUrl          string   `arg:"--apiurl" help:"API root to use instead of baseurl's api/v4/."`
+	RecordPath      string   `arg:"--record" help:"Record every request and response to a cassette file, with credentials and cookies scrubbed."`
+	ReplayPath      string   `arg:"--replay" help:"Answer requests from a cassette file made with --record instead of Beatport."`
+	TracePath       string   `arg:"--trace" help:"Log every request's URL, status, timing and size to this file, with secrets redacted."`
 }
 
 type Args struct {
-	Urls          []string `arg:"positional, required"`
-	OutPath       string   `arg:"-o" help:"Where to download to. Path will be made if it doesn't already exist."`
-	MaxCover      bool     `arg:"-m" help:"true = max cover size, false = 600x600."`
-	AlbumTemplate string   `arg:"-a" help:"Album folder naming template. Vars: album, albumArtist, catalogNumber, upc, year."`
-	TrackTemplate string   `arg:"-t" help:"Track filename naming template. Vars: album, albumArtist, artist, bpm, genre, isrc, title, track, trackPad, trackTotal, year."`
-}
-
-type UserSub struct {
-	Subscription struct {
-		UpdatedPersonID       int         `json:"updated_person_id"`
-		CreatedPersonID       int         `json:"created_person_id"`
-		ID                    int         `json:"id"`
-		Person                int         `json:"person"`
-		Notes                 interface{} `json:"notes"`
-		FreeTrialStartDate    string      `json:"free_trial_start_date"`
-		FreeTrialEndDate      string      `json:"free_trial_end_date"`
-		RecurlySubscriptionID string      `json:"recurly_subscription_id"`
-		Bundle                struct {
-			UpdatedPersonID int    `json:"updated_person_id"`
-			CreatedPersonID int    `json:"created_person_id"`
-			ID              int    `json:"id"`
-			Name            string `json:"name"`
-			Description     string `json:"description"`
-			Enabled         bool   `json:"enabled"`
-			PlanCode        string `json:"plan_code"`
-		} `json:"bundle"`
-		StartDate                 string `json:"start_date"`
-		EndDate                   string `json:"end_date"`
-		RecurlySubscriptionStatus string `json:"recurly_subscription_status"`
-		RecurlyInvoiceStatus      string `json:"recurly_invoice_status"`
-	} `json:"subscription"`
-	BillingInfo struct {
-		Type      string `json:"type"`
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Address1  string `json:"address1"`
-		Address2  string `json:"address2"`
-		City      string `json:"city"`
-		State     string `json:"state"`
-		Zip       string `json:"zip"`
-		Country   string `json:"country"`
-		CardType  string `json:"card_type"`
-		LastFour  string `json:"last_four"`
-	} `json:"billing_info"`
-	Active bool     `json:"active"`
-	Status []string `json:"status"`
-}
-
-type Artist struct {
-	ID    int `json:"id"`
-	Image struct {
-		ID         int    `json:"id"`
-		URI        string `json:"uri"`
-		DynamicURI string `json:"dynamic_uri"`
-	} `json:"image"`
-	Name string `json:"name"`
-	Slug string `json:"slug"`
-	URL  string `json:"url"`
-}
-
-type AlbumMeta struct {
-	Artists  []Artist `json:"artists"`
-	BpmRange struct {
-		Min int `json:"min"`
-		Max int `json:"max"`
-	} `json:"bpm_range"`
-	CatalogNumber string      `json:"catalog_number"`
-	Desc          string      `json:"desc"`
-	Enabled       bool        `json:"enabled"`
-	EncodedDate   string      `json:"encoded_date"`
-	Exclusive     bool        `json:"exclusive"`
-	Grid          interface{} `json:"grid"`
-	ID            int         `json:"id"`
-	Image         struct {
-		ID         int    `json:"id"`
-		URI        string `json:"uri"`
-		DynamicURI string `json:"dynamic_uri"`
-	} `json:"image"`
-	IsAvailableForStreaming bool `json:"is_available_for_streaming"`
-	Label                   struct {
-		ID    int    `json:"id"`
-		Name  string `json:"name"`
-		Image struct {
-			ID         int    `json:"id"`
-			URI        string `json:"uri"`
-			DynamicURI string `json:"dynamic_uri"`
-		} `json:"image"`
-		Slug string `json:"slug"`
-	} `json:"label"`
-	Name           string      `json:"name"`
-	NewReleaseDate string      `json:"new_release_date"`
-	OverridePrice  interface{} `json:"override_price"`
-	PreOrder       bool        `json:"pre_order"`
-	PreOrderDate   interface{} `json:"pre_order_date"`
-	Price          struct {
-		Code    string  `json:"code"`
-		Symbol  string  `json:"symbol"`
-		Value   float64 `json:"value"`
-		Display string  `json:"display"`
-	} `json:"price"`
-	PriceOverrideFirm bool          `json:"price_override_firm"`
-	PublishDate       string        `json:"publish_date"`
-	Remixers          []interface{} `json:"remixers"`
-	Slug              string        `json:"slug"`
-	Tracks            []string      `json:"tracks"`
-	TrackCount        int           `json:"track_count"`
-	Type              struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-	} `json:"type"`
-	Upc     interface{} `json:"upc"`
-	Updated string      `json:"updated"`
-	IsHype  bool        `json:"is_hype"`
-}
-
-type TrackMeta struct {
-	Artists            []Artist    `json:"artists"`
-	AudioFormat        interface{} `json:"audio_format"`
-	AvailableWorldwide bool        `json:"available_worldwide"`
-	Bpm                int         `json:"bpm"`
-	CatalogNumber      string      `json:"catalog_number"`
-	CurrentStatus      struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		URL  string `json:"url"`
-	} `json:"current_status"`
-	Desc            string `json:"desc"`
-	Enabled         bool   `json:"enabled"`
-	EncodeStatus    string `json:"encode_status"`
-	EncodedDate     string `json:"encoded_date"`
-	Exclusive       bool   `json:"exclusive"`
-	ExclusivePeriod struct {
-		Days        int    `json:"days"`
-		Description string `json:"description"`
-		ID          int    `json:"id"`
-		URL         string `json:"url"`
-	} `json:"exclusive_period"`
-	FreeDownloads         []interface{} `json:"free_downloads"`
-	FreeDownloadStartDate interface{}   `json:"free_download_start_date"`
-	FreeDownloadEndDate   interface{}   `json:"free_download_end_date"`
-	Genre                 struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Slug string `json:"slug"`
-		URL  string `json:"url"`
-	} `json:"genre"`
-	Hidden bool `json:"hidden"`
-	ID     int  `json:"id"`
-	Image  struct {
-		ID         int    `json:"id"`
-		URI        string `json:"uri"`
-		DynamicURI string `json:"dynamic_uri"`
-	} `json:"image"`
-	IsAvailableForStreaming bool        `json:"is_available_for_streaming"`
-	IsClassic               bool        `json:"is_classic"`
-	Isrc                    interface{} `json:"isrc"`
-	Key                     struct {
-		CamelotNumber int    `json:"camelot_number"`
-		CamelotLetter string `json:"camelot_letter"`
-		ChordType     struct {
-			ID   int    `json:"id"`
-			Name string `json:"name"`
-			URL  string `json:"url"`
-		} `json:"chord_type"`
-		ID      int    `json:"id"`
-		IsSharp bool   `json:"is_sharp"`
-		IsFlat  bool   `json:"is_flat"`
-		Letter  string `json:"letter"`
-		Name    string `json:"name"`
-		URL     string `json:"url"`
-	} `json:"key"`
-	LabelTrackIdentifier string      `json:"label_track_identifier"`
-	Length               string      `json:"length"`
-	LengthMs             int         `json:"length_ms"`
-	MixName              string      `json:"mix_name"`
-	Name                 string      `json:"name"`
-	NewReleaseDate       string      `json:"new_release_date"`
-	Number               int         `json:"number"`
-	PreOrder             bool        `json:"pre_order"`
-	PreOrderDate         interface{} `json:"pre_order_date"`
-	Price                struct {
-		Code    string  `json:"code"`
-		Symbol  string  `json:"symbol"`
-		Value   float64 `json:"value"`
-		Display string  `json:"display"`
-	} `json:"price"`
-	PublishDate   string `json:"publish_date"`
-	PublishStatus string `json:"publish_status"`
-	Release       struct {
-		ID    int    `json:"id"`
-		Name  string `json:"name"`
-		Image struct {
-			ID         int    `json:"id"`
-			URI        string `json:"uri"`
-			DynamicURI string `json:"dynamic_uri"`
-		} `json:"image"`
-		Label struct {
-			ID    int    `json:"id"`
-			Name  string `json:"name"`
-			Image struct {
-				ID         int    `json:"id"`
-				URI        string `json:"uri"`
-				DynamicURI string `json:"dynamic_uri"`
-			} `json:"image"`
-			Slug string `json:"slug"`
-		} `json:"label"`
-		Slug string `json:"slug"`
-	} `json:"release"`
-	Remixers []interface{} `json:"remixers"`
-	SaleType struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		URL  string `json:"url"`
-	} `json:"sale_type"`
-	SampleURL        string      `json:"sample_url"`
-	SampleStartMs    int         `json:"sample_start_ms"`
-	SampleEndMs      int         `json:"sample_end_ms"`
-	Slug             string      `json:"slug"`
-	SubGenre         interface{} `json:"sub_genre"`
-	WasEverExclusive bool        `json:"was_ever_exclusive"`
-	IsHype           bool        `json:"is_hype"`
-}
-
-type TrackStream struct {
-	StreamURL     string `json:"stream_url"`
-	SampleStartMs int    `json:"sample_start_ms"`
-	SampleEndMs   int    `json:"sample_end_ms"`
-}
-
-type Segments struct {
-	Key         []byte
-	IV          []byte
-	SegmentUrls []string
+	CommonArgs
+	Urls []string `arg:"positional, required" cfg:"-"`
+	JSON bool     `arg:"--json" help:"Print newline-delimited JSON events to stdout. Everything else goes to stderr."`
+}
+
+type DaemonConfig struct {
+	Listen   string
+	JobsPath string
+	Token    string `secret:"true"`
+}
+
+type WatchArgs struct {
+	CommonArgs
+	Interval  *int     `arg:"-i" help:"Minutes between checks for new releases." cfg:"Watch.Interval"`
+	Labels    []string `arg:"--label, separate" help:"Label URL or ID to watch." cfg:"Watch.Labels"`
+	Artists   []string `arg:"--artist, separate" help:"Artist URL or ID to watch." cfg:"Watch.Artists"`
+	StatePath string   `arg:"--statepath" help:"Where to keep the IDs of releases already seen." cfg:"Watch.StatePath"`
+	MarkSeen  bool     `arg:"--mark-seen" help:"Mark all current releases as seen on the first check instead of downloading them." cfg:"-"`
+	Once      bool     `help:"Check once and exit." cfg:"-"`
+	JSON      bool     `arg:"--json" help:"Print newline-delimited JSON events to stdout. Everything else goes to stderr."`
+}
+
+type Segment struct {
+	Url           string
+	Key           []byte
+	IV            []byte
+	Offset        int64
+	Limit         int64
+	Discontinuity bool
+}
+
+// What validateSegments found in a track's segments. The format's the first segment's.
+type AudioInfo struct {
+	Frames     int
+	SampleRate int
+	Channels   int
+	Duration   time.Duration
+	// The format changes at a discontinuity, so the segments can't just be copied into one track.
+	Changed bool
+}
+
+type Playlist struct {
+	Bandwidth uint32
+	Bitrate   int
+	Segments  []*Segment
+}
+
+type TrackFilter struct {
+	Field  string
+	Values []string
+	Min    int
+	Max    int
+	Bool   bool
+}
+
+type FilteredTrack struct {
+	Album  string
+	Title  string
+	Reason string
+}
+
+type DaemonArgs struct {
+	CommonArgs
+	Listen   string `arg:"-l" help:"Address for the HTTP API to listen on." cfg:"Daemon.Listen"`
+	JobsPath string `arg:"--jobs" help:"Where to keep the job queue." cfg:"Daemon.JobsPath"`
+	Token    string `arg:"--token" help:"Token API requests must send. Needed to listen on anything but loopback." cfg:"Daemon.Token"`
+}
+
+type FakeServerArgs struct {
+	Listen string `arg:"-l" help:"Address to listen on." default:"127.0.0.1:8421"`
+}
+
+type AccountArgs struct {
+	CommonArgs
+}
+
+type ConfigArgs struct {
+	CommonArgs
+	Action string `arg:"positional, required" help:"show or validate" cfg:"-"`
+}
+
+type WatchSource struct {
+	Kind string
+	ID   string
+}
+
+type WatchState struct {
+	Seen map[int]string `json:"seen"`
+}
+
+type JobTrack struct {
+	Num          int    `json:"num"`
+	ID           string `json:"id,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	Segment      int    `json:"segment"`
+	SegmentTotal int    `json:"segment_total"`
+}
+
+type Job struct {
+	ID         int         `json:"id"`
+	Url        string      `json:"url"`
+	Profile    string      `json:"profile,omitempty"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Album      string      `json:"album,omitempty"`
+	Cover      string      `json:"cover,omitempty"`
+	Tracks     []*JobTrack `json:"tracks"`
+	Cancelled  bool        `json:"cancelled"`
+	Created    time.Time   `json:"created"`
+	Updated    time.Time   `json:"updated"`
+	Log        []string    `json:"log,omitempty"`
+	store      *JobStore
+	cancelFunc context.CancelFunc
+}
+
+type JobStore struct {
+	NextID    int    `json:"next_id"`
+	Jobs      []*Job `json:"jobs"`
+	path      string
+	lock      *os.File
+	mu        sync.Mutex
+	dirty     bool
+	saveTimer *time.Timer
+}
+
+type Daemon struct {
+	ctx      context.Context
+	cfg      *Config
+	args     *DaemonArgs
+	sessions map[string]*Session
+	covers   *beatport.Client
+	tempPath string
+	jobs     *JobStore
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+// A profile's settings and the client it's signed in with.
+type Session struct {
+	cfg      *Config
+	client   *beatport.Client
+	signedIn bool
+}
+
+type SegmentManifest struct {
+	Total     int   `json:"total"`
+	Bitrate   int   `json:"bitrate"`
+	Completed []int `json:"completed"`
+}
+
+type Sidecar struct {
+	TrackID   string            `json:"track_id"`
+	Bitrate   int               `json:"bitrate,omitempty"`
+	Bandwidth uint32            `json:"bandwidth,omitempty"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type ExitError struct {
+	Code int
+	Msg  string
+	Err  error
+}
+
+type Event struct {
+	Event        string      `json:"event"`
+	Time         time.Time   `json:"time"`
+	Mode         string      `json:"mode,omitempty"`
+	Plan         string      `json:"plan,omitempty"`
+	Url          string      `json:"url,omitempty"`
+	Album        string      `json:"album,omitempty"`
+	Track        int         `json:"track,omitempty"`
+	TrackTotal   int         `json:"track_total,omitempty"`
+	TrackID      string      `json:"track_id,omitempty"`
+	Title        string      `json:"title,omitempty"`
+	Reason       string      `json:"reason,omitempty"`
+	Segment      int         `json:"segment,omitempty"`
+	SegmentTotal int         `json:"segment_total,omitempty"`
+	Path         string      `json:"path,omitempty"`
+	Bitrate      int         `json:"bitrate,omitempty"`
+	Context      string      `json:"context,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	Summary      *RunSummary `json:"summary,omitempty"`
+}
+
+type Progress struct {
+	mu         sync.Mutex
+	bars       []*ProgressBar
+	tty        bool
+	checked    bool
+	lines      int
+	rendered   time.Time
+	albumsDone int
+	albumTotal int
+	tracksDone int
+	trackTotal int
+}
+
+type ProgressBar struct {
+	Label        string
+	Bytes        int64
+	Segment      int
+	SegmentTotal int
+	progress     *Progress
+	started      time.Time
+	fetched      int
+	segBytes     int64
+	finished     bool
+}
+
+type RunSummary struct {
+	Downloaded   int `json:"downloaded"`
+	Skipped      int `json:"skipped"`
+	Filtered     int `json:"filtered"`
+	Failed       int `json:"failed"`
+	FailedAlbums int `json:"failed_albums"`
 }
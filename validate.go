@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/adts"
+)
+
+// Anything shorter than this under the track's length is treated as truncated.
+// Streams are cut on segment boundaries, so they rarely match to the millisecond.
+const durationTolerance = 2 * time.Second
+
+// Parses the ADTS frames of every decrypted segment and checks they add up to the track's length.
+// Segments have to match the ones before them, except at a discontinuity, where the format can change.
+func validateSegments(segPaths []string, segments []*Segment, lengthMs int) (*AudioInfo, error) {
+	var (
+		audio AudioInfo
+		run   = &adts.Info{}
+	)
+	endRun := func() {
+		if run.Frames == 0 {
+			return
+		}
+		if audio.Frames == 0 {
+			audio.SampleRate, audio.Channels = run.SampleRate, run.Channels
+		} else if run.SampleRate != audio.SampleRate || run.Channels != audio.Channels {
+			audio.Changed = true
+		}
+		audio.Frames += run.Frames
+		audio.Duration += run.Duration()
+		run = &adts.Info{}
+	}
+	for i, segPath := range segPaths {
+		data, err := ioutil.ReadFile(segPath)
+		if err != nil {
+			return nil, err
+		}
+		segInfo, err := adts.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("Segment %d of %d is invalid.\n%s", i+1, len(segPaths), err)
+		}
+		if i < len(segments) && segments[i].Discontinuity {
+			endRun()
+		}
+		err = run.Add(segInfo)
+		if err != nil {
+			return nil, fmt.Errorf("Segment %d of %d doesn't match the others.\n%s", i+1, len(segPaths), err)
+		}
+	}
+	endRun()
+	if audio.Frames == 0 {
+		return nil, errors.New("No audio frames.")
+	}
+	if lengthMs > 0 {
+		expected := time.Duration(lengthMs) * time.Millisecond
+		if audio.Duration < expected-durationTolerance {
+			return nil, fmt.Errorf(
+				"Track is truncated. Got %s of audio, expected %s.",
+				audio.Duration.Round(time.Millisecond), expected,
+			)
+		}
+	}
+	return &audio, nil
+}
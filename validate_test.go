@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/adts"
+)
+
+// A 1024 sample ADTS frame with a one byte payload. Sample rate index 4 is 44.1 kHz, 3 is 48 kHz.
+func adtsFrame(srIndex, channels int) []byte {
+	const frameLen = 8
+	return []byte{
+		0xff, 0xf1,
+		byte(1<<6 | srIndex<<2 | channels>>2),
+		byte(channels&3<<6 | frameLen>>11),
+		byte(frameLen >> 3 & 0xff),
+		byte(frameLen&7<<5 | 0x1f),
+		0xfc, 0x00,
+	}
+}
+
+func TestValidateSegments(t *testing.T) {
+	stereo44 := bytes.Repeat(adtsFrame(4, 2), 43)
+	stereo48 := bytes.Repeat(adtsFrame(3, 2), 47)
+	tests := []struct {
+		name          string
+		data          [][]byte
+		discontinuity []bool
+		lengthMs      int
+		changed       bool
+		wantErr       string
+	}{
+		{"same format", [][]byte{stereo44, stereo44}, []bool{false, false}, 2000, false, ""},
+		{"change without discontinuity", [][]byte{stereo44, stereo48}, []bool{false, false}, 2000, false, adts.ErrMismatch.Error()},
+		{"change at discontinuity", [][]byte{stereo44, stereo48}, []bool{false, true}, 2000, true, ""},
+		{"discontinuity without change", [][]byte{stereo44, stereo44}, []bool{false, true}, 2000, false, ""},
+		{"truncated", [][]byte{stereo44}, []bool{false}, 10000, false, "Track is truncated."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var (
+				segPaths []string
+				segments []*Segment
+			)
+			for i, data := range tt.data {
+				segPath := filepath.Join(dir, fmt.Sprintf("%03d.aac", i+1))
+				err := ioutil.WriteFile(segPath, data, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				segPaths = append(segPaths, segPath)
+				segments = append(segments, &Segment{Discontinuity: tt.discontinuity[i]})
+			}
+			audio, err := validateSegments(segPaths, segments, tt.lengthMs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if audio.Changed != tt.changed {
+				t.Errorf("got changed %t, want %t", audio.Changed, tt.changed)
+			}
+			// Each run's duration comes from its own sample rate.
+			var want time.Duration
+			for _, data := range tt.data {
+				info, _ := adts.Parse(data)
+				want += info.Duration()
+			}
+			if audio.Duration.Round(time.Millisecond) != want.Round(time.Millisecond) || audio.SampleRate != 44100 {
+				t.Errorf("got %s at %d Hz, want %s at 44100 Hz", audio.Duration, audio.SampleRate, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Sorrow446/Beatport-Downloader/beatport"
+)
+
+const (
+	watchRegexString = `^(label|artist)/[a-z0-9-]+/(\d+)$`
+	watchInterval    = 60
+	watchStatePath   = "watch_state.json"
+)
+
+func parseWatchCfg() (*Config, *WatchArgs, error) {
+	var args WatchArgs
+	err := parseSubArgs("watch", &args)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := readConfig(args.Config, args.Profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyArgs(cfg, &args)
+	if len(cfg.Watch.Labels) == 0 && len(cfg.Watch.Artists) == 0 {
+		return nil, nil, errors.New("No labels or artists to watch.")
+	}
+	err = setCfgDefaults(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, &args, nil
+}
+
+// Accepts either a label/artist URL or a bare ID.
+func checkWatchId(kind, value string) (string, error) {
+	_, err := strconv.Atoi(value)
+	if err == nil {
+		return value, nil
+	}
+	match := matchSiteUrl(value, watchRegexString)
+	if match == nil || match[1] != kind {
+		return "", errors.New("Invalid " + kind + " URL or ID: " + value)
+	}
+	return match[2], nil
+}
+
+func getWatchSources(cfg *Config) ([]*WatchSource, error) {
+	var sources []*WatchSource
+	for _, kind := range []string{"label", "artist"} {
+		values := cfg.Watch.Labels
+		if kind == "artist" {
+			values = cfg.Watch.Artists
+		}
+		for _, value := range values {
+			id, err := checkWatchId(kind, value)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, &WatchSource{Kind: kind, ID: id})
+		}
+	}
+	return sources, nil
+}
+
+func readWatchState(path string) (*WatchState, error) {
+	state := &WatchState{Seen: map[int]string{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Seen == nil {
+		state.Seen = map[int]string{}
+	}
+	return state, nil
+}
+
+func writeWatchState(path string, state *WatchState) error {
+	return writeJsonAtomic(path, state)
+}
+
+// Newest first. Stops paging at the first page where every release has already been seen,
+// unless all is set.
+func getSourceReleases(ctx context.Context, client *beatport.Client, source *WatchSource, state *WatchState, all bool) ([]*beatport.ReleaseSummary, error) {
+	var (
+		releases []*beatport.ReleaseSummary
+		page     *beatport.ReleaseList
+		err      error
+	)
+	if source.Kind == "artist" {
+		page, err = client.ArtistReleases(ctx, source.ID)
+	} else {
+		page, err = client.LabelReleases(ctx, source.ID)
+	}
+	for err == nil && page != nil {
+		allSeen := true
+		for _, release := range page.Results {
+			if _, ok := state.Seen[release.ID]; !ok {
+				allSeen = false
+			}
+			releases = append(releases, release)
+		}
+		if allSeen && !all {
+			break
+		}
+		page, err = client.NextPage(ctx, page)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+// Sessions expire, so re-auth if the subscription endpoint stops letting us in.
+func checkSession(ctx context.Context, client *beatport.Client, cfg *Config) error {
+	_, err := client.Subscription(ctx)
+	if err == nil {
+		return nil
+	}
+	fmt.Println("Session expired, signing in again.")
+	return signIn(ctx, client, cfg)
+}
+
+func pollSources(ctx context.Context, client *beatport.Client, cfg *Config, sources []*WatchSource, state *WatchState, tempPath string, markSeen bool, summary *RunSummary) error {
+	var filtered []*FilteredTrack
+	jobs, _ := newJobStore("")
+	for _, source := range sources {
+		if isStopping() {
+			break
+		}
+		releases, err := getSourceReleases(ctx, client, source, state, markSeen)
+		if ctx.Err() != nil {
+			break
+		} else if err != nil {
+			handleErr("Failed to get releases for "+source.Kind+" "+source.ID+".", err)
+			continue
+		}
+		// Oldest first so an interrupted poll picks up where it left off.
+		for i := len(releases) - 1; i >= 0; i-- {
+			release := releases[i]
+			if _, ok := state.Seen[release.ID]; ok {
+				continue
+			}
+			if !markSeen {
+				fmt.Printf("New release from %s %s:\n", source.Kind, source.ID)
+				releaseUrl := client.ReleaseURL(release)
+				job := jobs.add(releaseUrl, cfg.Profile)
+				albumFiltered, err := processAlbum(ctx, client, cfg, tempPath, strconv.Itoa(release.ID), releaseUrl, job)
+				filtered = append(filtered, albumFiltered...)
+				summary.addJob(job, err)
+				// Not marked as seen, so it's picked up again next time.
+				if err == errInterrupted {
+					break
+				} else if err != nil {
+					fmt.Println(err)
+					job.emit(&Event{Event: eventError, Context: "release", Error: err.Error()}, 0)
+					continue
+				}
+				// Nor are releases with failed tracks, so those are retried. The ones that made it are skipped then.
+				if job.trackErr() != nil {
+					continue
+				}
+			}
+			state.Seen[release.ID] = release.Name
+			// Marking alone is quick, so that's saved once per source below.
+			if markSeen {
+				continue
+			}
+			err = writeWatchState(cfg.Watch.StatePath, state)
+			if err != nil {
+				return err
+			}
+		}
+		if markSeen {
+			err = writeWatchState(cfg.Watch.StatePath, state)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Marked %d release(s) from %s %s as seen.\n", len(releases), source.Kind, source.ID)
+		}
+	}
+	printFilterReport(filtered)
+	return nil
+}
+
+// Ctrl+C is the usual way out, so the exit code only reflects whether anything failed along the way.
+func watch(ctx context.Context, client *beatport.Client, cfg *Config, args *WatchArgs, tempPath string) error {
+	sources, err := getWatchSources(cfg)
+	if err != nil {
+		return configErr("Failed to parse watch sources.", err)
+	}
+	state, err := readWatchState(cfg.Watch.StatePath)
+	if err != nil {
+		return fatalErr("Failed to read watch state.", err)
+	}
+	var summary RunSummary
+	interval := time.Duration(cfg.Watch.Interval) * time.Minute
+	markSeen := args.MarkSeen
+	for !isStopping() {
+		err = checkSession(ctx, client, cfg)
+		if ctx.Err() != nil {
+			break
+		} else if err != nil {
+			handleErr("Failed to auth.", err)
+		} else {
+			fmt.Printf("Checking %d source(s) for new releases.\n", len(sources))
+			err = pollSources(ctx, client, cfg, sources, state, tempPath, markSeen, &summary)
+			if err != nil {
+				return fatalErr("Failed to write watch state.", err)
+			}
+			markSeen = false
+		}
+		if args.Once || isStopping() {
+			break
+		}
+		fmt.Printf("Next check in %d minute(s).\n", cfg.Watch.Interval)
+		select {
+		case <-time.After(interval):
+		case <-stopping:
+		}
+	}
+	summary.print()
+	emit(&Event{Event: eventRunFinished, Summary: &summary})
+	return summary.err()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+func webHandler() http.Handler {
+	sub, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
+
+// Proxied through the daemon so the page never has to load anything from outside.
+func (d *Daemon) serveCover(w http.ResponseWriter, r *http.Request, job *Job) {
+	d.jobs.mu.Lock()
+	cover := job.Cover
+	d.jobs.mu.Unlock()
+	if cover == "" {
+		d.writeError(w, http.StatusNotFound, "No cover yet.")
+		return
+	}
+	// Covers are public, so they get a client of their own rather than a profile's.
+	req, err := d.covers.Get(r.Context(), strings.Replace(cover, "{w}x{h}", "150x150", 1))
+	if err != nil {
+		d.writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer req.Body.Close()
+	if req.StatusCode != http.StatusOK {
+		d.writeError(w, http.StatusBadGateway, req.Status)
+		return
+	}
+	w.Header().Set("Content-Type", req.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "max-age=86400")
+	io.Copy(w, req.Body)
+}